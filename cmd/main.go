@@ -9,6 +9,8 @@ import (
 	"github.com/JonnyShabli/23.07.2025/config"
 	"github.com/JonnyShabli/23.07.2025/internal/Service"
 	d "github.com/JonnyShabli/23.07.2025/internal/Service/downloader"
+	"github.com/JonnyShabli/23.07.2025/internal/Service/janitor"
+	"github.com/JonnyShabli/23.07.2025/internal/Service/recovery"
 	"github.com/JonnyShabli/23.07.2025/internal/Service/zipper"
 	"github.com/JonnyShabli/23.07.2025/internal/controller"
 	"github.com/JonnyShabli/23.07.2025/internal/repository"
@@ -49,16 +51,26 @@ func main() {
 	})
 
 	// создаем pool для скачивания файлов
-	downloaderPool := d.NewDownloader(appConfig.Pool, logger)
+	downloaderPool, err := d.NewDownloader(appConfig.Pool, logger)
+	if err != nil {
+		panic(err)
+	}
 
 	// создаем зависимости
-	repo := repository.NewStorage(logger)
+	repo, err := repository.New(appConfig.Storage, logger)
+	if err != nil {
+		panic(err)
+	}
 	// создаем zipper
-	zipperMgr := zipper.NewZipper(appConfig.Zipper, logger, downloaderPool.Out, repo)
+	zipperMgr := zipper.NewZipper(appConfig.Zipper, logger, downloaderPool.Out, repo, downloaderPool.CallbackClient())
 
-	service := Service.NewServiceObj(repo, logger, downloaderPool)
-	handlerObj := controller.NewHandlers(service, logger, appConfig.HttpServer.Addr+":"+appConfig.HttpServer.Port)
+	service := Service.NewServiceObj(repo, logger, downloaderPool, zipperMgr)
+	handlerObj := controller.NewHandlers(appConfig.Api, service, logger, appConfig.HttpServer.Addr+":"+appConfig.HttpServer.Port)
 
+	err = downloaderPool.PrepareSpool()
+	if err != nil {
+		panic(err)
+	}
 	go downloaderPool.StartDownloader(ctx)
 
 	_, err = os.Stat(appConfig.Zipper.ArchivePath)
@@ -69,12 +81,25 @@ func main() {
 		}
 	}
 
+	// сверяем задачи в хранилище с архивами на диске
+	err = recovery.NewRecovery(appConfig.Recovery, appConfig.Zipper.ArchivePath, repo, downloaderPool, logger).Run(ctx)
+	if err != nil {
+		panic(err)
+	}
+
 	g.Go(func() error {
 		return logster.LogIfError(
 			logger, zipperMgr.Start(ctx, logger), "Zipper manager",
 		)
 	})
 
+	// удаляем просроченные задачи и архивы
+	g.Go(func() error {
+		return logster.LogIfError(
+			logger, janitor.NewJanitor(appConfig.Janitor, repo, logger).Start(ctx), "Janitor",
+		)
+	})
+
 	// создаем хэндлер
 	handler := pkghttp.NewHandler("/", pkghttp.WithLogger(logger), pkghttp.DefaultTechOptions(), controller.WithApiHandler(handlerObj))
 	logger.Infof("Create and configure handler")
@@ -4,17 +4,25 @@ import (
 	"os"
 
 	"github.com/JonnyShabli/23.07.2025/internal/Service/downloader"
+	"github.com/JonnyShabli/23.07.2025/internal/Service/janitor"
+	"github.com/JonnyShabli/23.07.2025/internal/Service/recovery"
 	"github.com/JonnyShabli/23.07.2025/internal/Service/zipper"
+	"github.com/JonnyShabli/23.07.2025/internal/controller"
+	"github.com/JonnyShabli/23.07.2025/internal/repository"
 	pkghttp "github.com/JonnyShabli/23.07.2025/pkg/http"
 	"github.com/JonnyShabli/23.07.2025/pkg/logster"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	HttpServer pkghttp.HTTPServer    `yaml:"http_server"`
-	Logger     logster.Config        `yaml:"logger"`
-	Pool       downloader.PoolConfig `yaml:"worker_pool"`
-	Zipper     zipper.ZipperConfig   `yaml:"zipper"`
+	HttpServer pkghttp.HTTPServer       `yaml:"http_server"`
+	Logger     logster.Config           `yaml:"logger"`
+	Pool       downloader.PoolConfig    `yaml:"worker_pool"`
+	Zipper     zipper.ZipperConfig      `yaml:"zipper"`
+	Storage    repository.StorageConfig `yaml:"storage"`
+	Recovery   recovery.RecoveryConfig  `yaml:"recovery"`
+	Janitor    janitor.JanitorConfig    `yaml:"janitor"`
+	Api        controller.ApiConfig     `yaml:"api"`
 }
 
 func LoadConfig(filename string, cfg interface{}) error {
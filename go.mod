@@ -3,14 +3,12 @@ module github.com/JonnyShabli/23.07.2025
 go 1.23.2
 
 require (
+	github.com/go-chi/chi/v5 v5.2.2
+	github.com/google/uuid v1.6.0
 	github.com/joho/godotenv v1.5.1
+	go.uber.org/zap v1.27.0
+	golang.org/x/sync v0.16.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/go-chi/chi/v5 v5.2.2 // indirect
-	github.com/google/uuid v1.6.0 // indirect
-	go.uber.org/multierr v1.11.0 // indirect
-	go.uber.org/zap v1.27.0 // indirect
-	golang.org/x/sync v0.16.0 // indirect
-)
+require go.uber.org/multierr v1.11.0 // indirect
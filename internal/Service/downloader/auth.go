@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+type authKey struct{}
+
+// authScope - учетные данные ссылки и адрес, для которого они переданы
+type authScope struct {
+	auth   *models.Auth
+	origin *url.URL
+}
+
+// withAuth передает учетные данные в authTransport через контекст запроса,
+// так они применяются и к запросам после редиректов
+func withAuth(ctx context.Context, auth *models.Auth, origin *url.URL) context.Context {
+	if auth == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, authKey{}, authScope{auth: auth, origin: origin})
+}
+
+// allows - запрос идет на тот же хост и порт, что и ссылка, и не по открытому каналу вместо https
+func (s authScope) allows(u *url.URL) bool {
+	if !strings.EqualFold(u.Hostname(), s.origin.Hostname()) || effectivePort(u) != effectivePort(s.origin) {
+		return false
+	}
+	return !(strings.EqualFold(s.origin.Scheme, "https") && !strings.EqualFold(u.Scheme, "https"))
+}
+
+func effectivePort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		return "443"
+	case "http":
+		return "80"
+	}
+	return ""
+}
+
+// authTransport добавляет учетные данные к запросам на хост ссылки. Заголовки
+// не выставляются в исходном запросе, поэтому http.Client не переносит их при редиректе.
+type authTransport struct {
+	next http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	scope, ok := req.Context().Value(authKey{}).(authScope)
+	if !ok || !scope.allows(req.URL) {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	applyAuth(req.Header, scope.auth)
+	return t.next.RoundTrip(req)
+}
+
+func applyAuth(header http.Header, auth *models.Auth) {
+	for name, value := range auth.Headers {
+		header.Set(name, string(value))
+	}
+	switch {
+	case auth.Bearer != "":
+		header.Set("Authorization", "Bearer "+string(auth.Bearer))
+	case auth.Basic != nil:
+		credentials := auth.Basic.Username + ":" + string(auth.Basic.Password)
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if len(auth.Cookies) > 0 {
+		header.Set("Cookie", auth.CookieHeader())
+	}
+}
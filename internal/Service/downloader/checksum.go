@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+func mismatch(format string, args ...interface{}) error {
+	return classed(models.ErrorClassChecksum, fmt.Errorf("%w: %s", ErrChecksumMismatch, fmt.Sprintf(format, args...)))
+}
+
+// digestAlgo - алгоритм ожидаемой контрольной суммы задания, пустая строка - сумма не передана
+func digestAlgo(job models.DownloadJob) string {
+	if job.ExpectedDigest == "" {
+		return ""
+	}
+	algo, _, err := models.ParseDigest(job.ExpectedDigest)
+	if err != nil {
+		return ""
+	}
+	return algo
+}
+
+func newHash(algo string) hash.Hash {
+	switch algo {
+	case models.DigestSha1:
+		return sha1.New()
+	case models.DigestMd5:
+		return md5.New()
+	default:
+		return sha256.New()
+	}
+}
+
+// spoolHashes - суммы, которые считаются при записи временного файла:
+// sha256 всегда и сумма алгоритмом клиента, если он другой
+type spoolHashes struct {
+	sha256 hash.Hash
+	algo   string
+	digest hash.Hash
+}
+
+func newSpoolHashes(algo string) *spoolHashes {
+	h := &spoolHashes{sha256: sha256.New(), algo: algo}
+	switch algo {
+	case "":
+	case models.DigestSha256:
+		h.digest = h.sha256
+	default:
+		h.digest = newHash(algo)
+	}
+	return h
+}
+
+func (h *spoolHashes) Write(p []byte) (int, error) {
+	h.sha256.Write(p)
+	if h.digest != nil && h.digest != h.sha256 {
+		h.digest.Write(p)
+	}
+	return len(p), nil
+}
+
+func (h *spoolHashes) Reset() {
+	h.sha256.Reset()
+	if h.digest != nil {
+		h.digest.Reset()
+	}
+}
+
+func (h *spoolHashes) result(result *models.ZipJob) {
+	result.Sha256 = hex.EncodeToString(h.sha256.Sum(nil))
+	if h.digest != nil {
+		result.Digest = h.algo + ":" + hex.EncodeToString(h.digest.Sum(nil))
+	}
+}
+
+// checkExpectedSize сверяет заявленный сервером размер с переданным клиентом,
+// offset - сколько байт уже скачано, size < 0 - размер неизвестен
+func checkExpectedSize(job models.DownloadJob, offset, size int64) error {
+	if job.ExpectedSize <= 0 || size < 0 {
+		return nil
+	}
+	if offset+size != job.ExpectedSize {
+		return mismatch("server announced %d bytes, expected %d", offset+size, job.ExpectedSize)
+	}
+	return nil
+}
+
+// verifyChecksum сверяет скачанный файл с размером и суммой, переданными клиентом
+func verifyChecksum(job models.DownloadJob, result models.ZipJob) error {
+	if job.ExpectedSize > 0 && result.Size != job.ExpectedSize {
+		return mismatch("got %d bytes, expected %d", result.Size, job.ExpectedSize)
+	}
+	if job.ExpectedDigest != "" && result.Digest != job.ExpectedDigest {
+		return mismatch("got %s, expected %s", result.Digest, job.ExpectedDigest)
+	}
+	return nil
+}
@@ -1,14 +1,14 @@
 package downloader
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
-	"io"
-	"mime"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
-	"path/filepath"
 	"sync"
 	"time"
 
@@ -16,20 +16,50 @@ import (
 	"github.com/JonnyShabli/23.07.2025/pkg/logster"
 )
 
+const (
+	cancelledTTL = time.Hour
+	// taskUsageTTL - через сколько забыть объем, скачанный задачей без активных загрузок.
+	// Задача, у которой ссылки еще скачиваются, свой бюджет не теряет.
+	taskUsageTTL = 24 * time.Hour
+)
+
+var ErrTaskCancelled = errors.New("task cancelled")
+
 type DownloaderInterface interface {
 	StartDownloader(ctx context.Context)
 	AddJob(job models.DownloadJob)
+	CancelTask(taskId string)
+	CheckUrl(rawUrl string) error
 }
 
 type PoolConfig struct {
-	timeout      time.Duration `yaml:"timeout"`
-	NumWorkers   int           `yaml:"num_workers"`
-	AllowedTypes []string      `yaml:"allowed_types"`
-	MaxFileSize  int64         `yaml:"max_file_size"`
+	NumWorkers   int      `yaml:"num_workers"`
+	AllowedTypes []string `yaml:"allowed_types"`
+	MaxFileSize  int64    `yaml:"max_file_size"`
+	// MaxTaskSize - сколько байт всего можно скачать для одной задачи, 0 - без ограничения
+	MaxTaskSize int64 `yaml:"max_task_size"`
+	// SpoolDir - директория для временных файлов скачиваемых ссылок
+	SpoolDir string      `yaml:"spool_dir"`
+	Retry    RetryConfig `yaml:"retry"`
+	// TypePolicy - как определять тип файла: detected (по умолчанию), strict или declared
+	TypePolicy string `yaml:"type_policy"`
+	// HostDefaults - ограничения для каждого хоста, Hosts - переопределения для отдельных
+	// хостов, ключ - имя хоста или маска вида *.example.com
+	HostDefaults HostConfig            `yaml:"host_defaults"`
+	Hosts        map[string]HostConfig `yaml:"hosts"`
+	// Guard - ограничения на адреса, которые можно скачивать
+	Guard GuardConfig `yaml:"guard"`
+	// Fetchers - источники для схем адресов кроме http и https
+	Fetchers FetchersConfig `yaml:"fetchers"`
+	// Transport - прокси и настройки TLS для исходящих соединений
+	Transport TransportConfig `yaml:"transport"`
+	Timeouts  TimeoutConfig   `yaml:"timeouts"`
+	Redirects RedirectConfig  `yaml:"redirects"`
 }
 
 type Downloader struct {
 	client       http.Client
+	callback     *http.Client
 	numWorkers   int
 	In           chan models.DownloadJob
 	Out          chan models.ZipJob
@@ -37,15 +67,70 @@ type Downloader struct {
 	logger       logster.Logger
 	allowedTypes []string
 	maxFileSize  int64
+	maxTaskSize  int64
+	retry        retryPolicy
+	spoolDir     string
+	typePolicy   string
+	guard        *guard
+	hosts        *hostLimiter
+	fetchers     map[string]Fetcher
+	timeouts     TimeoutConfig
+
+	mu        sync.Mutex
+	inflight  map[string]*taskJobs
+	cancelled map[string]time.Time
+	usage     map[string]*taskUsage
 }
 
-func NewDownloader(cfg PoolConfig, logger logster.Logger) *Downloader {
-	logger.Infof("Downloader pool created")
+// taskJobs - контекст скачиваемых сейчас файлов задачи, его отмена прерывает их загрузку
+type taskJobs struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	count  int
+}
+
+func NewDownloader(cfg PoolConfig, logger logster.Logger) (*Downloader, error) {
+	guard, err := newGuard(cfg.Guard)
+	if err != nil {
+		return nil, err
+	}
+	timeouts := cfg.Timeouts.withDefaults()
+	transport, err := newTransport(cfg.Transport, timeouts, guard)
+	if err != nil {
+		return nil, err
+	}
+	spoolDir := cfg.SpoolDir
+	if spoolDir == "" {
+		spoolDir = defaultSpoolDir
+	}
+	typePolicy := cfg.TypePolicy
+	if typePolicy == "" {
+		typePolicy = TypePolicyDetected
+	}
+	hosts, err := newHostLimiter(cfg.HostDefaults, cfg.Hosts)
+	if err != nil {
+		return nil, err
+	}
 	client := http.Client{
-		Timeout: cfg.timeout,
+		CheckRedirect: newRedirectPolicy(cfg.Redirects).check,
+		Transport: &authTransport{
+			next: &guardTransport{
+				guard: guard,
+				next: &hostTransport{
+					next:   transport,
+					limits: hosts,
+				},
+			},
+		},
+	}
+	// уведомления уходят на адреса клиентов, поэтому проходят те же проверки, что и скачивание
+	callback := &http.Client{
+		CheckRedirect: client.CheckRedirect,
+		Transport:     &guardTransport{guard: guard, next: transport},
 	}
-	return &Downloader{
+	d := &Downloader{
 		client:       client,
+		callback:     callback,
 		numWorkers:   cfg.NumWorkers,
 		In:           make(chan models.DownloadJob),
 		Out:          make(chan models.ZipJob),
@@ -53,12 +138,156 @@ func NewDownloader(cfg PoolConfig, logger logster.Logger) *Downloader {
 		logger:       logger,
 		allowedTypes: cfg.AllowedTypes,
 		maxFileSize:  cfg.MaxFileSize,
+		maxTaskSize:  cfg.MaxTaskSize,
+		retry:        newRetryPolicy(cfg.Retry),
+		spoolDir:     spoolDir,
+		typePolicy:   typePolicy,
+		guard:        guard,
+		hosts:        hosts,
+		fetchers:     make(map[string]Fetcher),
+		timeouts:     timeouts,
+		inflight:     make(map[string]*taskJobs),
+		cancelled:    make(map[string]time.Time),
+		usage:        make(map[string]*taskUsage),
+	}
+	err = d.registerFetchers(cfg.Fetchers, cfg.Transport)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Downloader pool created")
+	return d, nil
+}
+
+// CallbackClient - клиент для отправки уведомлений на адреса клиентов
+func (d *Downloader) CallbackClient() *http.Client {
+	return d.callback
+}
+
+// CheckUrl проверяет адрес, на который загрузчик отправит запрос: схему, порт, хост и IP, если он указан в адресе.
+// Адреса, полученные по DNS, проверяются при соединении.
+func (d *Downloader) CheckUrl(rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return blocked(ErrBlockedUrl, "%s", err)
+	}
+	err = d.guard.checkUrl(u)
+	if err != nil {
+		return err
+	}
+	if _, err := netip.ParseAddr(u.Hostname()); err == nil {
+		return d.guard.checkAddr(net.JoinHostPort(u.Hostname(), effectivePort(u)))
+	}
+	return nil
+}
+
+// CancelTask прерывает загрузки задачи, а ее еще не начатые задания завершаются ошибкой
+func (d *Downloader) CancelTask(taskId string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for id, at := range d.cancelled {
+		if now.Sub(at) > cancelledTTL {
+			delete(d.cancelled, id)
+		}
+	}
+	d.cancelled[taskId] = now
+	delete(d.usage, taskId)
+
+	if jobs, ok := d.inflight[taskId]; ok {
+		jobs.cancel()
+	}
+	d.logger.Infof("Downloads of task %s cancelled", taskId)
+}
+
+// acquire возвращает контекст для задания задачи, release нужно вызвать по его завершении
+func (d *Downloader) acquire(ctx context.Context, taskId string) (context.Context, func(), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.cancelled[taskId]; ok {
+		return nil, nil, ErrTaskCancelled
+	}
+	jobs, ok := d.inflight[taskId]
+	if !ok {
+		jobCtx, cancel := context.WithCancel(ctx)
+		jobs = &taskJobs{ctx: jobCtx, cancel: cancel}
+		d.inflight[taskId] = jobs
+	}
+	jobs.count++
+
+	release := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		jobs.count--
+		if jobs.count == 0 {
+			jobs.cancel()
+			delete(d.inflight, taskId)
+		}
+	}
+	return jobs.ctx, release, nil
+}
+
+func (d *Downloader) process(ctx context.Context, job models.DownloadJob) models.ZipJob {
+	jobCtx, release, err := d.acquire(ctx, job.TaskId)
+	if err != nil {
+		return models.ZipJob{TaskId: job.TaskId, Index: job.Index, Url: job.Url, Err: err, ErrorClass: models.ErrorClassCancelled}
+	}
+	defer release()
+
+	result := d.download(jobCtx, job)
+	if result.Err != nil && errors.Is(jobCtx.Err(), context.Canceled) && ctx.Err() == nil {
+		result.Err = ErrTaskCancelled
+	}
+	result.FinishedAt = time.Now()
+	if result.Err != nil {
+		result.ErrorClass = errorClass(result.Err)
+		result.Transient = isTransient(result.Err)
+	}
+	return result
+}
+
+// download скачивает файл, повторяя попытки после временных ошибок согласно политике повторов.
+// Недокачанный файл сохраняется между попытками, чтобы продолжить с места обрыва.
+func (d *Downloader) download(ctx context.Context, job models.DownloadJob) models.ZipJob {
+	if d.timeouts.Total > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, d.timeouts.Total,
+			fmt.Errorf("%w: not finished in %s", ErrTotalTimeout, d.timeouts.Total))
+		defer cancel()
+	}
+	startedAt := time.Now()
+	part := &partial{}
+	var result models.ZipJob
+	for attempt := 1; ; attempt++ {
+		result = d.downloadFromURL(ctx, job, d.allowedTypes, part)
+		result.Attempts = attempt
+		result.StartedAt = startedAt
+		if result.Err == nil {
+			return result
+		}
+		if attempt >= d.retry.maxAttempts || !isTransient(result.Err) {
+			d.dropPartial(job.TaskId, part)
+			return result
+		}
+
+		delay := d.retry.backoff(attempt, retryAfterOf(result.Err))
+		d.logger.WithError(result.Err).Infof("attempt %d for %s failed, retry in %s", attempt, models.RedactedUrl(job.Url), delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			d.dropPartial(job.TaskId, part)
+			result.Err = contextTimeout(ctx, result.Err)
+			return result
+		case <-timer.C:
+		}
 	}
 }
 
 func (d *Downloader) AddJob(job models.DownloadJob) {
 	d.In <- job
-	d.logger.Infof("Job added, %-v", job)
+	d.logger.Infof("Job added, task %s link %d %s", job.TaskId, job.Index, models.RedactedUrl(job.Url))
 }
 
 func (d *Downloader) StartDownloader(ctx context.Context) {
@@ -71,7 +300,7 @@ func (d *Downloader) StartDownloader(ctx context.Context) {
 				select {
 				case <-ctx.Done():
 					return
-				case d.Out <- d.downloadFromURL(ctx, job, d.allowedTypes):
+				case d.Out <- d.process(ctx, job):
 
 				}
 			}
@@ -91,13 +320,13 @@ func (d *Downloader) processData(ctx context.Context, job models.DownloadJob) mo
 		TaskId: job.TaskId,
 	}
 	go func() {
-		doneCh <- d.downloadFromURL(ctx, job, d.allowedTypes)
+		doneCh <- d.downloadFromURL(ctx, job, d.allowedTypes, &partial{})
 	}()
 
 	select {
 	case v := <-doneCh:
-		result.Data = v.Data
-		result.ResponseStatus = v.ResponseStatus
+		result.SpoolPath = v.SpoolPath
+		result.HttpCode = v.HttpCode
 		return result
 	case <-ctx.Done():
 		d.logger.Infof("Job not finished: %s", ctx.Err().Error())
@@ -106,75 +335,101 @@ func (d *Downloader) processData(ctx context.Context, job models.DownloadJob) mo
 	}
 }
 
-func (d *Downloader) downloadFromURL(ctx context.Context, job models.DownloadJob, allowedTypes []string) models.ZipJob {
+// downloadFromURL делает одну попытку скачивания. Если в part есть недокачанный файл,
+// запрашивается только его продолжение.
+func (d *Downloader) downloadFromURL(ctx context.Context, job models.DownloadJob, allowedTypes []string, part *partial) models.ZipJob {
 	var allowed bool = false
 	result := models.ZipJob{
 		TaskId: job.TaskId,
+		Index:  job.Index,
 		Url:    job.Url,
 	}
-	doneCh := make(chan models.ZipJob)
+	// буфер, чтобы горутина не зависла, если результат уже никто не ждет
+	doneCh := make(chan models.ZipJob, 1)
 	select {
 	default:
 	case <-ctx.Done():
-		result.Err = ctx.Err()
+		result.Err = contextTimeout(ctx, ctx.Err())
 		return result
 	}
+	// контекст попытки отменяется, если тело перестало приходить
+	attemptCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
 	go func() {
-		// получаем заголовки
-		respHead, err := d.client.Head(job.Url)
+		// запрашиваем файл у источника по схеме адреса
+		response, err := d.fetch(attemptCtx, job, part)
+		if response != nil {
+			result.HttpCode = response.StatusCode
+			result.FinalUrl = response.FinalUrl
+			result.Redirects = response.Redirects
+		}
 		if err != nil {
+			if errors.Is(err, ErrRangeMismatch) {
+				d.resetPartial(job.TaskId, part)
+			}
 			result.Err = err
 			doneCh <- result
 			return
 		}
-		defer respHead.Body.Close()
-		// проверяем размер файла
-		contentLength := respHead.ContentLength
-		if contentLength > d.maxFileSize {
-			result.Err = fmt.Errorf("file size %d exceeds maximum allowed size %d", contentLength, d.maxFileSize)
+		response.Body = newIdleBody(response.Body, d.timeouts.BodyIdle, cancel)
+		defer response.Body.Close()
+
+		// докачиваем файл, если источник продолжил его с нужного места
+		resume := false
+		if response.Offset > 0 {
+			if response.Offset != part.size {
+				d.resetPartial(job.TaskId, part)
+				result.Err = rangeError(fmt.Errorf("%w: got range from %d, have %d bytes", ErrRangeMismatch, response.Offset, part.size))
+				doneCh <- result
+				return
+			}
+			resume = true
+			d.logger.Infof("resume %s from byte %d", models.RedactedUrl(job.Url), part.size)
+		} else {
+			// файл у источника изменился или докачка не поддерживается, качаем заново
+			d.resetPartial(job.TaskId, part)
+			part.validator = response.Validator
+		}
+
+		// размер и заявленный тип проверяем по заголовкам, до чтения тела
+		declared := mediaType(response.ContentType)
+		result.DeclaredType = declared
+		if !declaredAllowed(d.typePolicy, declared, allowedTypes) {
+			result.Err = classed(models.ErrorClassType, fmt.Errorf("declared type %s is not allowed", declared))
 			doneCh <- result
 			return
 		}
-		// запрашиваем файл
-		response, err := d.client.Get(job.Url)
+		err = d.checkSize(job.TaskId, part.size, response.Size)
+		if err == nil {
+			err = checkExpectedSize(job, part.size, response.Size)
+		}
 		if err != nil {
 			result.Err = err
 			doneCh <- result
 			return
 		}
-		defer response.Body.Close()
 
-		// получаем статус код ответа
-		result.ResponseStatus = response.Status
-		// получаем имя файл
-		parsedURL, err := url.Parse(job.Url)
+		// получаем тип файла по содержимому и сверяем с заявленным сервером
+		reader := bufio.NewReaderSize(response.Body, sniffLen)
+		detected := detectType(sniffHead(reader, part, resume))
+		mimeType, err := resolveType(d.typePolicy, declared, detected, extensionType(job.Url))
 		if err != nil {
-			result.Err = err
+			result.Err = typeError(err)
 			doneCh <- result
 			return
 		}
-		filename := filepath.Base(parsedURL.Path)
-		if filename == "." || filename == "/" {
-			filename = "unnamed_file"
-		}
-		result.FileName = filename
-
-		// получаем тип файла
-		contentType := response.Header.Get("Content-Type")
-		if contentType == "" {
-			err = errors.New("content type is empty")
-			result.Err = err
+		if mimeType == "" {
+			result.Err = classed(models.ErrorClassType, errors.New("content type is empty"))
 			doneCh <- result
 			return
 		}
-
-		mimeType, _, err := mime.ParseMediaType(contentType)
-		if err != nil {
-			result.Err = err
-			doneCh <- result
-			return
+		if declared != "" && mimeType != declared {
+			d.logger.Infof("%s declared as %s, detected %s", models.RedactedUrl(job.Url), declared, mimeType)
 		}
+		result.ContentType = mimeType
+		// получаем имя файла
+		result.FileName = entryName(response.FileName, response.FinalUrl, mimeType)
 		for _, t := range allowedTypes {
 			if t == mimeType {
 				allowed = true
@@ -184,17 +439,28 @@ func (d *Downloader) downloadFromURL(ctx context.Context, job models.DownloadJob
 
 		// проверяем допустимость типа файла
 		if allowed {
-			data, err := io.ReadAll(response.Body)
+			body := d.limitBody(job.TaskId, part.size, reader)
+			err = d.spoolBody(body, &result, part, resume, digestAlgo(job))
+			part.reserved += body.read
 			if err != nil {
+				// докачать можно только после временной ошибки
+				if !isTransient(err) || !part.resumable() {
+					d.resetPartial(job.TaskId, part)
+				}
 				result.Err = err
 				doneCh <- result
 				return
 			}
-			result.Data = &data
+			err = verifyChecksum(job, result)
+			if err != nil {
+				d.resetPartial(job.TaskId, part)
+				result.SpoolPath = ""
+				result.Err = err
+			}
 			doneCh <- result
 			return
 		} else {
-			result.Err = fmt.Errorf("%s type  is not allowed", mimeType)
+			result.Err = classed(models.ErrorClassType, fmt.Errorf("%s type  is not allowed", mimeType))
 			doneCh <- result
 			return
 		}
@@ -202,9 +468,24 @@ func (d *Downloader) downloadFromURL(ctx context.Context, job models.DownloadJob
 
 	select {
 	case <-ctx.Done():
-		result.Err = fmt.Errorf("job not finished: %w", ctx.Err().Error())
+		// загрузка могла успеть завершиться, временный файл тогда не нужен
+		part.detached = true
+		go func() {
+			<-doneCh
+			d.resetPartial(job.TaskId, part)
+		}()
+		result.Err = contextTimeout(ctx, fmt.Errorf("job not finished: %w", ctx.Err()))
 		return result
 	case v := <-doneCh:
+		v.Err = contextTimeout(attemptCtx, v.Err)
 		return v
 	}
 }
+
+// remaining - сколько байт файла размера size осталось скачать после offset, -1 - неизвестно
+func remaining(size, offset int64) int64 {
+	if size < 0 {
+		return -1
+	}
+	return max(size-offset, 0)
+}
@@ -0,0 +1,43 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+// DownloadError - ошибка скачивания с классом, который сохраняется в записи о ссылке.
+// Transient - ошибка временная и попытку можно повторить, RetryAfter - пауза, запрошенная сервером.
+type DownloadError struct {
+	Class      string
+	Err        error
+	Transient  bool
+	RetryAfter time.Duration
+}
+
+func (e *DownloadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+func classed(class string, err error) error {
+	return &DownloadError{Class: class, Err: err}
+}
+
+// errorClass определяет класс ошибки, неклассифицированные ошибки считаются сетевыми
+func errorClass(err error) string {
+	var downloadErr *DownloadError
+	switch {
+	case errors.As(err, &downloadErr):
+		return downloadErr.Class
+	case errors.Is(err, ErrTaskCancelled), errors.Is(err, context.Canceled):
+		return models.ErrorClassCancelled
+	default:
+		return models.ErrorClassNetwork
+	}
+}
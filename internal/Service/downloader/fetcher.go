@@ -0,0 +1,115 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+// Fetcher получает файл по адресу одной схемы. Проверки типа, размера и контрольной
+// суммы общие для всех схем и делаются загрузчиком после Fetch.
+type Fetcher interface {
+	Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error)
+}
+
+// FetchRequest - запрос файла. Offset и Validator заданы, если есть недокачанный файл:
+// источник может продолжить с Offset, если файл не изменился с момента выдачи Validator.
+type FetchRequest struct {
+	Job       models.DownloadJob
+	Url       *url.URL
+	Offset    int64
+	Validator string
+}
+
+// FetchResponse - ответ источника. При ошибке Fetch может вернуть ответ без Body,
+// код и адрес из него сохраняются в записи о ссылке.
+type FetchResponse struct {
+	Body io.ReadCloser
+	// Size - сколько байт в Body, -1 - неизвестно
+	Size int64
+	// Offset - с какого байта файла начинается Body: 0 или запрошенный Offset
+	Offset int64
+	// Validator - для докачки следующей попыткой, пустой - докачка невозможна
+	Validator string
+	// ContentType - тип, заявленный источником, FileName - имя файла от источника
+	ContentType string
+	FileName    string
+	FinalUrl    string
+	StatusCode  int
+	// Redirects - цепочка редиректов до FinalUrl
+	Redirects []models.Redirect
+}
+
+// FetchersConfig - настройки схем кроме http и https. Схема без настроек выключена.
+type FetchersConfig struct {
+	Data DataConfig `yaml:"data"`
+	File FileConfig `yaml:"file"`
+	Ftp  FtpConfig  `yaml:"ftp"`
+	S3   S3Config   `yaml:"s3"`
+}
+
+// RegisterFetcher подключает источник для схемы адресов, заменяя прежний
+func (d *Downloader) RegisterFetcher(scheme string, fetcher Fetcher) {
+	d.fetchers[strings.ToLower(scheme)] = fetcher
+}
+
+// registerFetchers подключает встроенные источники, включенные в настройках
+func (d *Downloader) registerFetchers(cfg FetchersConfig, transport TransportConfig) error {
+	web := &httpFetcher{d: d, client: &d.client}
+	d.RegisterFetcher("http", web)
+	d.RegisterFetcher("https", web)
+	if cfg.Data.Enabled {
+		d.RegisterFetcher("data", dataFetcher{})
+	}
+	if cfg.File.Root != "" {
+		fetcher, err := newFileFetcher(cfg.File)
+		if err != nil {
+			return err
+		}
+		d.RegisterFetcher("file", fetcher)
+	}
+	if cfg.Ftp.Enabled {
+		d.RegisterFetcher("ftp", newFtpFetcher(d.guard, d.timeouts.Dial))
+	}
+	if cfg.S3.Endpoint != "" {
+		fetcher, err := newS3Fetcher(cfg.S3, transport, d)
+		if err != nil {
+			return err
+		}
+		d.RegisterFetcher("s3", fetcher)
+	}
+	return nil
+}
+
+// fetch находит источник по схеме адреса и запрашивает у него файл
+func (d *Downloader) fetch(ctx context.Context, job models.DownloadJob, part *partial) (*FetchResponse, error) {
+	u, err := url.Parse(job.Url)
+	if err != nil {
+		// url.Error содержит адрес целиком, вместе с паролем
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, classed(models.ErrorClassBlocked, fmt.Errorf("%w: %w", ErrBlockedUrl, err))
+	}
+	fetcher, ok := d.fetchers[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, blocked(ErrBlockedUrl, "scheme %q is not supported", u.Scheme)
+	}
+	req := FetchRequest{Job: job, Url: u}
+	if part.resumable() {
+		req.Offset = part.size
+		req.Validator = part.validator
+	}
+	return fetcher.Fetch(ctx, req)
+}
+
+// notFound - источник сообщил, что файла нет
+func notFound(err error) error {
+	return classed(models.ErrorClassNotFound, err)
+}
@@ -0,0 +1,58 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+)
+
+var ErrBadDataUrl = errors.New("bad data url")
+
+// DataConfig - адреса вида data:[<тип>][;base64],<данные> по RFC 2397
+type DataConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// dataFetcher отдает данные, записанные прямо в адресе
+type dataFetcher struct{}
+
+func (dataFetcher) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	// разбираем исходную строку, url.Parse кладет все в Opaque и может его перекодировать
+	_, rest, _ := strings.Cut(req.Job.Url, ":")
+	meta, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return nil, ErrBadDataUrl
+	}
+
+	var data []byte
+	var err error
+	meta, isBase64 := strings.CutSuffix(meta, ";base64")
+	if isBase64 {
+		payload, err = url.PathUnescape(payload)
+		if err == nil {
+			payload = strings.TrimRight(strings.Join(strings.Fields(payload), ""), "=")
+			data, err = base64.RawStdEncoding.DecodeString(payload)
+		}
+	} else {
+		var text string
+		text, err = url.PathUnescape(payload)
+		data = []byte(text)
+	}
+	if err != nil {
+		return nil, errors.Join(ErrBadDataUrl, err)
+	}
+	if meta == "" {
+		meta = "text/plain;charset=US-ASCII"
+	}
+
+	return &FetchResponse{
+		Body:        io.NopCloser(bytes.NewReader(data)),
+		Size:        int64(len(data)),
+		ContentType: meta,
+		FinalUrl:    req.Url.Redacted(),
+	}, nil
+}
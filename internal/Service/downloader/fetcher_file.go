@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileConfig - адреса вида file:///путь, Root - единственная директория, из которой можно
+// брать файлы. Пустой Root - схема выключена.
+type FileConfig struct {
+	Root string `yaml:"root"`
+}
+
+type fileFetcher struct {
+	root string
+}
+
+func newFileFetcher(cfg FileConfig) (*fileFetcher, error) {
+	root, err := filepath.Abs(cfg.Root)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file fetcher root %s: %w", cfg.Root, err)
+	}
+	return &fileFetcher{root: root}, nil
+}
+
+// resolve возвращает путь к файлу после раскрытия символических ссылок,
+// если он лежит внутри разрешенной директории
+func (f *fileFetcher) resolve(path string) (string, error) {
+	// путь за пределами Root отклоняется до обращения к файловой системе,
+	// иначе по разным ошибкам можно узнать, какие файлы есть на сервере
+	clean := filepath.Clean(path)
+	if !within(f.root, clean) {
+		return "", blocked(ErrBlockedUrl, "%s is outside of %s", path, f.root)
+	}
+	real, err := filepath.EvalSymlinks(clean)
+	if os.IsNotExist(err) {
+		return "", notFound(err)
+	}
+	if err != nil {
+		return "", err
+	}
+	// символическая ссылка внутри Root может вести наружу
+	if !within(f.root, real) {
+		return "", blocked(ErrBlockedUrl, "%s is outside of %s", path, f.root)
+	}
+	return real, nil
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (f *fileFetcher) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	if req.Url.Host != "" && req.Url.Host != "localhost" {
+		return nil, blocked(ErrBlockedUrl, "remote file host %s", req.Url.Host)
+	}
+	path, err := f.resolve(req.Url.Path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, notFound(fmt.Errorf("%s is not a regular file", req.Url.Path))
+	}
+
+	result := &FetchResponse{
+		Size:        info.Size(),
+		Validator:   fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano()),
+		ContentType: extensionType(path),
+		FinalUrl:    req.Url.Redacted(),
+	}
+	// докачиваем, только если файл не менялся
+	if req.Offset > 0 && req.Validator == result.Validator && req.Offset <= info.Size() {
+		_, err = file.Seek(req.Offset, io.SeekStart)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		result.Offset = req.Offset
+		result.Size = info.Size() - req.Offset
+	}
+	result.Body = file
+	return result, nil
+}
@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+func TestFileFetcherResolve(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, p := range []string{filepath.Join(root, "a.pdf"), filepath.Join(root, "sub", "b.pdf"), filepath.Join(outside, "secret.pdf")} {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("%PDF-1.4"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.pdf"), filepath.Join(root, "out.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "outdir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "a.pdf"), filepath.Join(root, "in.pdf")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := newFileFetcher(FileConfig{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// class - пустой, если путь разрешен
+	tests := []struct {
+		path  string
+		class string
+	}{
+		{"a.pdf", ""},
+		{"sub/b.pdf", ""},
+		{"sub/../a.pdf", ""},
+		{"in.pdf", ""},
+		{"missing.pdf", models.ErrorClassNotFound},
+		{"../" + filepath.Base(outside) + "/secret.pdf", models.ErrorClassBlocked},
+		{"sub/../../" + filepath.Base(outside) + "/secret.pdf", models.ErrorClassBlocked},
+		{"out.pdf", models.ErrorClassBlocked},
+		{"outdir/secret.pdf", models.ErrorClassBlocked},
+	}
+	for _, tt := range tests {
+		_, err := f.resolve(filepath.Join(root, tt.path))
+		if tt.class == "" {
+			if err != nil {
+				t.Errorf("resolve(%s): %v", tt.path, err)
+			}
+			continue
+		}
+		if class := errorClass(err); class != tt.class {
+			t.Errorf("resolve(%s) = %v, want class %s", tt.path, err, tt.class)
+		}
+	}
+
+	// за пределами Root существующий и несуществующий файлы неразличимы
+	_, errExisting := f.resolve(filepath.Join(outside, "secret.pdf"))
+	_, errMissing := f.resolve(filepath.Join(outside, "missing.pdf"))
+	if !errors.Is(errExisting, ErrBlockedUrl) || !errors.Is(errMissing, ErrBlockedUrl) {
+		t.Errorf("outside root: existing %v, missing %v, want %v for both", errExisting, errMissing, ErrBlockedUrl)
+	}
+}
@@ -0,0 +1,212 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+const defaultFtpPort = "21"
+
+// FtpConfig - адреса вида ftp://[user:password@]host[:port]/path. Соединения проходят
+// те же проверки адресов, что и http, схему ftp и порт нужно разрешить в guard.
+type FtpConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// ftpFetcher - минимальный клиент FTP: вход, пассивный режим и RETR с докачкой через REST
+type ftpFetcher struct {
+	guard *guard
+	dial  func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+func newFtpFetcher(guard *guard, dialTimeout time.Duration) *ftpFetcher {
+	return &ftpFetcher{guard: guard, dial: timeoutDial(guard.dialer(dialTimeout))}
+}
+
+// ftpError - ответ сервера с ошибкой, коды 4xx временные
+func ftpError(err error) error {
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) {
+		return err
+	}
+	if protoErr.Code == 550 {
+		return notFound(err)
+	}
+	return &DownloadError{Class: models.ErrorClassFtpStatus, Err: err, Transient: protoErr.Code/100 == 4}
+}
+
+type ftpConn struct {
+	conn net.Conn
+	text *textproto.Conn
+	stop func() bool
+}
+
+// cmd отправляет команду и читает ответ, expect - ожидаемый код или его первые цифры
+func (c *ftpConn) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	id, err := c.text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	c.text.StartResponse(id)
+	defer c.text.EndResponse(id)
+	return c.text.ReadResponse(expect)
+}
+
+func (c *ftpConn) Close() error {
+	c.stop()
+	return c.text.Close()
+}
+
+func (f *ftpFetcher) login(ctx context.Context, req FetchRequest) (*ftpConn, error) {
+	err := f.guard.checkUrl(req.Url)
+	if err != nil {
+		return nil, err
+	}
+	// пользователь из адреса, иначе из basic авторизации ссылки
+	user, pass := "anonymous", "anonymous@"
+	if req.Url.User != nil {
+		user = req.Url.User.Username()
+		if p, ok := req.Url.User.Password(); ok {
+			pass = p
+		}
+	} else if auth := req.Job.Auth; auth != nil && auth.Basic != nil {
+		user, pass = auth.Basic.Username, string(auth.Basic.Password)
+	}
+	// url.Parse раскодирует %0d%0a, такие значения дописали бы к команде свою
+	if !ftpArg(user) || !ftpArg(pass) || !ftpArg(req.Url.Path) {
+		return nil, blocked(ErrBlockedUrl, "control characters in ftp user, password or path")
+	}
+	port := req.Url.Port()
+	if port == "" {
+		port = defaultFtpPort
+	}
+	conn, err := f.dial(ctx, "tcp", net.JoinHostPort(req.Url.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	c := &ftpConn{
+		conn: conn,
+		text: textproto.NewConn(conn),
+		// отмена контекста прерывает ожидание ответа сервера
+		stop: context.AfterFunc(ctx, func() { conn.Close() }),
+	}
+
+	_, _, err = c.text.ReadResponse(2)
+	if err != nil {
+		c.Close()
+		return nil, ftpError(err)
+	}
+	code, _, err := c.cmd(0, "USER %s", user)
+	if err == nil && code == 331 {
+		_, _, err = c.cmd(2, "PASS %s", pass)
+	} else if err == nil && code/100 != 2 {
+		err = &textproto.Error{Code: code, Msg: "login failed"}
+	}
+	if err == nil {
+		_, _, err = c.cmd(200, "TYPE I")
+	}
+	if err != nil {
+		c.Close()
+		return nil, ftpError(err)
+	}
+	return c, nil
+}
+
+// ftpArg - значение можно передать аргументом команды: в нем нет переводов строки и NUL
+func ftpArg(value string) bool {
+	return !strings.ContainsAny(value, "\r\n\x00")
+}
+
+// passive открывает соединение для данных. Адрес из ответа PASV игнорируется,
+// иначе сервер мог бы направить нас на произвольный хост.
+func (f *ftpFetcher) passive(ctx context.Context, c *ftpConn) (net.Conn, error) {
+	_, msg, err := c.cmd(227, "PASV")
+	if err != nil {
+		return nil, ftpError(err)
+	}
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("bad PASV response %q", msg)
+	}
+	fields := strings.Split(msg[start+1:end], ",")
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("bad PASV response %q", msg)
+	}
+	p1, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+	p2, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("bad PASV response %q", msg)
+	}
+	host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+	return f.dial(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(p1<<8+p2)))
+}
+
+func (f *ftpFetcher) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	c, err := f.login(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	path := req.Url.Path
+
+	result := &FetchResponse{Size: -1, ContentType: extensionType(path), FinalUrl: req.Url.Redacted()}
+	// размер и время изменения нужны для проверки размера и докачки, но сервер может их не поддерживать
+	if _, msg, err := c.cmd(213, "SIZE %s", path); err == nil {
+		if size, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64); err == nil {
+			result.Size = size
+		}
+	}
+	if result.Size >= 0 {
+		_, modified, err := c.cmd(213, "MDTM %s", path)
+		if err == nil {
+			result.Validator = fmt.Sprintf("%d-%s", result.Size, strings.TrimSpace(modified))
+		}
+	}
+	if req.Offset > 0 && req.Validator != "" && req.Validator == result.Validator {
+		if _, _, err := c.cmd(350, "REST %d", req.Offset); err == nil {
+			result.Offset = req.Offset
+			result.Size -= req.Offset
+		}
+	}
+
+	data, err := f.passive(ctx, c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	_, _, err = c.cmd(1, "RETR %s", path)
+	if err != nil {
+		data.Close()
+		c.Close()
+		return nil, ftpError(err)
+	}
+	result.Body = &ftpBody{
+		Conn: data,
+		ctrl: c,
+		// таймауты и отмена задачи отменяют контекст, это прерывает и чтение данных
+		stop: context.AfterFunc(ctx, func() { data.Close() }),
+	}
+	return result, nil
+}
+
+// ftpBody - соединение с данными, при закрытии завершает сессию
+type ftpBody struct {
+	net.Conn
+	ctrl *ftpConn
+	stop func() bool
+}
+
+func (b *ftpBody) Close() error {
+	b.stop()
+	err := b.Conn.Close()
+	b.ctrl.cmd(0, "QUIT")
+	b.ctrl.Close()
+	return err
+}
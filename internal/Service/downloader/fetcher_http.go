@@ -0,0 +1,115 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// httpFetcher скачивает файлы по http и https. prepare меняет запрос перед отправкой,
+// например, адрес и подпись для S3.
+type httpFetcher struct {
+	d       *Downloader
+	client  *http.Client
+	prepare func(req *http.Request) error
+}
+
+func (f *httpFetcher) newRequest(ctx context.Context, method string, u *url.URL) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.prepare != nil {
+		err = f.prepare(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// head запрашивает только заголовки файла, ответ с кодом ошибки тоже считается ошибкой
+func (f *httpFetcher) head(reqHead *http.Request) (*http.Response, error) {
+	respHead, err := f.client.Do(reqHead)
+	if err != nil {
+		return respHead, transportTimeout(err)
+	}
+	// тело HEAD не нужно, соединение освобождаем сразу
+	respHead.Body.Close()
+	if respHead.StatusCode >= http.StatusBadRequest {
+		return respHead, f.d.retry.statusError(respHead, fmt.Errorf("unexpected HEAD response status %s", respHead.Status))
+	}
+	return respHead, nil
+}
+
+func (f *httpFetcher) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	ctx = withAuth(ctx, req.Job.Auth, req.Url)
+	// получаем заголовки, если хост поддерживает HEAD
+	reqHead, err := f.newRequest(ctx, http.MethodHead, req.Url)
+	if err != nil {
+		return nil, err
+	}
+	mode := f.d.hosts.config(hostKey(reqHead)).Head
+	if mode != HeadNever {
+		respHead, err := f.head(reqHead)
+		switch {
+		case err == nil:
+			// проверяем заявленный размер файла, фактический проверяется при скачивании
+			err = f.d.checkSize(req.Job.TaskId, req.Offset, remaining(respHead.ContentLength, req.Offset))
+			if err != nil {
+				return nil, err
+			}
+		case mode == HeadAlways || ctx.Err() != nil:
+			return errorResponse(respHead), err
+		default:
+			// размер и тип проверит загрузчик по заголовкам ответа на GET
+			f.d.logger.Infof("HEAD %s failed, continue with GET: %s", req.Url.Redacted(), err)
+		}
+	}
+
+	// запрашиваем файл, при докачке - только его продолжение
+	reqGet, err := f.newRequest(ctx, http.MethodGet, req.Url)
+	if err != nil {
+		return nil, err
+	}
+	if req.Offset > 0 && req.Validator != "" {
+		reqGet.Header.Set("Range", fmt.Sprintf("bytes=%d-", req.Offset))
+		// If-Range гарантирует, что при изменении файла на сервере придет весь файл, а не его кусок
+		reqGet.Header.Set("If-Range", req.Validator)
+	}
+	response, err := f.client.Do(reqGet)
+	if err != nil {
+		return errorResponse(response), transportTimeout(err)
+	}
+
+	// получаем статус код ответа и адрес после редиректов
+	result := &FetchResponse{
+		Size:        response.ContentLength,
+		ContentType: response.Header.Get("Content-Type"),
+		FileName:    dispositionName(response.Header.Get("Content-Disposition")),
+		FinalUrl:    response.Request.URL.Redacted(),
+		StatusCode:  response.StatusCode,
+		Redirects:   redirectChain(response),
+	}
+	if response.StatusCode == http.StatusRequestedRangeNotSatisfiable && req.Offset > 0 {
+		response.Body.Close()
+		return result, rangeError(fmt.Errorf("%w: %s", ErrRangeMismatch, response.Status))
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		response.Body.Close()
+		return result, f.d.retry.statusError(response, fmt.Errorf("unexpected response status %s", response.Status))
+	}
+	if response.StatusCode == http.StatusPartialContent && req.Offset > 0 {
+		start, err := parseContentRange(response.Header.Get("Content-Range"))
+		if err != nil {
+			response.Body.Close()
+			return result, rangeError(err)
+		}
+		result.Offset = start
+	} else {
+		result.Validator = rangeValidator(response)
+	}
+	result.Body = response.Body
+	return result, nil
+}
@@ -0,0 +1,109 @@
+package downloader
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+	"github.com/JonnyShabli/23.07.2025/pkg/logster"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-central-1"
+	// sha256 пустой строки из документации AWS
+	testEmptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// checkSigV4 проверяет подпись запроса так, как это делает хранилище
+func checkSigV4(r *http.Request) error {
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != testEmptyHash {
+		return fmt.Errorf("x-amz-content-sha256 %q", got)
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len("20060102T150405Z") {
+		return fmt.Errorf("x-amz-date %q", amzDate)
+	}
+	scope := amzDate[:8] + "/" + testRegion + "/s3/aws4_request"
+	canonical := r.Method + "\n" +
+		r.URL.EscapedPath() + "\n" +
+		r.URL.RawQuery + "\n" +
+		"host:" + r.Host + "\n" +
+		"x-amz-content-sha256:" + testEmptyHash + "\n" +
+		"x-amz-date:" + amzDate + "\n\n" +
+		"host;x-amz-content-sha256;x-amz-date\n" +
+		testEmptyHash
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range []string{amzDate[:8], testRegion, "s3", "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	want := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=%s",
+		testAccessKey, scope, hex.EncodeToString(key))
+	if got := r.Header.Get("Authorization"); got != want {
+		return fmt.Errorf("authorization %q, want %q", got, want)
+	}
+	return nil
+}
+
+func TestS3FetcherSignsRequests(t *testing.T) {
+	var paths []string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.EscapedPath())
+		if err := checkSigV4(r); err != nil {
+			t.Errorf("%s %s: %v", r.Method, r.URL, err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		io.WriteString(w, "%PDF-1.4 test")
+	}))
+	defer storage.Close()
+
+	logger := logster.New(zapcore.AddSync(io.Discard), logster.Config{Project: "test", Level: "error", Format: "text"})
+	d, err := NewDownloader(PoolConfig{
+		Fetchers: FetchersConfig{S3: S3Config{
+			Endpoint:  storage.URL,
+			Region:    testRegion,
+			AccessKey: testAccessKey,
+			SecretKey: testSecretKey,
+		}},
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("s3://bucket/dir/report 2025.pdf")
+	resp, err := d.fetchers["s3"].Fetch(context.Background(), FetchRequest{
+		Job: models.DownloadJob{TaskId: "task", Url: u.String()},
+		Url: u,
+	})
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "%PDF-1.4 test" {
+		t.Errorf("body %q", body)
+	}
+
+	want := []string{"HEAD /bucket/dir/report%202025.pdf", "GET /bucket/dir/report%202025.pdf"}
+	if strings.Join(paths, ", ") != strings.Join(want, ", ") {
+		t.Errorf("requests %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,203 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+var (
+	ErrBlockedUrl     = errors.New("url is not allowed")
+	ErrBlockedAddress = errors.New("address is not allowed")
+)
+
+var (
+	defaultSchemes = []string{"http", "https"}
+	defaultPorts   = []int{80, 443}
+)
+
+// GuardConfig - какие адреса разрешено скачивать, защищает от запросов к внутренним сервисам
+type GuardConfig struct {
+	// AllowPrivate - разрешить loopback, частные, link-local и multicast адреса, только для отладки
+	AllowPrivate bool `yaml:"allow_private"`
+	// AllowNets - внутренние сети, к которым все же можно обращаться, например 10.1.0.0/16
+	AllowNets []string `yaml:"allow_nets"`
+	Schemes   []string `yaml:"schemes"`
+	Ports     []int    `yaml:"ports"`
+	// AllowHosts - если задан, скачивать можно только с этих хостов, DenyHosts - запрещенные хосты.
+	// Поддерживаются маски вида *.example.com
+	AllowHosts []string `yaml:"allow_hosts"`
+	DenyHosts  []string `yaml:"deny_hosts"`
+}
+
+// blockedNets - диапазоны, которые не распознаются методами netip.Addr
+var blockedNets = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+type guard struct {
+	allowPrivate bool
+	allowNets    []netip.Prefix
+	schemes      []string
+	ports        []int
+	allowHosts   map[string]struct{}
+	denyHosts    map[string]struct{}
+}
+
+func newGuard(cfg GuardConfig) (*guard, error) {
+	g := &guard{
+		allowPrivate: cfg.AllowPrivate,
+		schemes:      cfg.Schemes,
+		ports:        cfg.Ports,
+		allowHosts:   hostSet(cfg.AllowHosts),
+		denyHosts:    hostSet(cfg.DenyHosts),
+	}
+	if len(g.schemes) == 0 {
+		g.schemes = defaultSchemes
+	}
+	if len(g.ports) == 0 {
+		g.ports = defaultPorts
+	}
+	for _, n := range cfg.AllowNets {
+		prefix, err := netip.ParsePrefix(n)
+		if err != nil {
+			return nil, fmt.Errorf("parse allowed net %s failed: %w", n, err)
+		}
+		g.allowNets = append(g.allowNets, prefix)
+	}
+	return g, nil
+}
+
+func hostSet(hosts []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(hosts))
+	for _, h := range hosts {
+		set[strings.ToLower(h)] = struct{}{}
+	}
+	return set
+}
+
+func blocked(err error, format string, args ...interface{}) error {
+	return classed(models.ErrorClassBlocked, fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...)))
+}
+
+// checkUrl проверяет схему, порт и хост адреса до обращения к нему
+func (g *guard) checkUrl(u *url.URL) error {
+	if !slices.Contains(g.schemes, u.Scheme) {
+		return blocked(ErrBlockedUrl, "scheme %q", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return blocked(ErrBlockedUrl, "empty host")
+	}
+	if _, denied := matchHost(g.denyHosts, host); denied {
+		return blocked(ErrBlockedUrl, "host %s is denied", host)
+	}
+	if _, allowed := matchHost(g.allowHosts, host); len(g.allowHosts) > 0 && !allowed {
+		return blocked(ErrBlockedUrl, "host %s is not in allow list", host)
+	}
+
+	port := u.Port()
+	if port == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || !slices.Contains(g.ports, n) {
+		return blocked(ErrBlockedUrl, "port %s", port)
+	}
+	return nil
+}
+
+// checkAddr проверяет адрес, к которому открывается соединение, то есть уже после
+// разрешения имени, поэтому подмена DNS не помогает обойти проверку
+func (g *guard) checkAddr(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return blocked(ErrBlockedAddress, "bad address %s", address)
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return blocked(ErrBlockedAddress, "bad address %s", address)
+	}
+	ip = ip.Unmap()
+	if g.allowPrivate {
+		return nil
+	}
+	for _, prefix := range g.allowNets {
+		if prefix.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return blocked(ErrBlockedAddress, "%s is internal", ip)
+	}
+	for _, prefix := range blockedNets {
+		if prefix.Contains(ip) {
+			return blocked(ErrBlockedAddress, "%s is reserved", ip)
+		}
+	}
+	return nil
+}
+
+// checkHost разрешает имя и проверяет все его адреса. Нужна, когда соединение с хостом
+// открывает не загрузчик, а прокси. Имя, которое не удалось разрешить, запрещено.
+func (g *guard) checkHost(ctx context.Context, host, port string) error {
+	if g.allowPrivate {
+		return nil
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return g.checkAddr(net.JoinHostPort(host, port))
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return blocked(ErrBlockedAddress, "resolve %s failed: %s", host, err)
+	}
+	for _, addr := range addrs {
+		err = g.checkAddr(net.JoinHostPort(addr.String(), port))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dialer открывает соединения только с разрешенными адресами
+func (g *guard) dialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return g.checkAddr(address)
+		},
+	}
+}
+
+// guardTransport проверяет адрес каждого запроса, в том числе после редиректа
+type guardTransport struct {
+	next  http.RoundTripper
+	guard *guard
+}
+
+func (t *guardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.guard.checkUrl(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,130 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+	"github.com/JonnyShabli/23.07.2025/pkg/logster"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestGuardCheckAddr(t *testing.T) {
+	g, err := newGuard(GuardConfig{AllowNets: []string{"10.1.0.0/16"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{"93.184.216.34:443", false},
+		{"[2606:4700:4700::1111]:443", false},
+		{"10.1.2.3:80", false},
+		{"10.2.0.1:80", true},
+		{"127.0.0.1:80", true},
+		{"172.16.0.1:80", true},
+		{"192.168.1.1:80", true},
+		{"169.254.169.254:80", true},
+		{"100.64.0.1:80", true},
+		{"0.0.0.0:80", true},
+		{"198.18.0.1:80", true},
+		{"240.0.0.1:80", true},
+		{"224.0.0.1:80", true},
+		{"[::1]:80", true},
+		{"[fe80::1]:80", true},
+		{"[fc00::1]:80", true},
+		{"[::ffff:127.0.0.1]:80", true},
+		{"[::ffff:169.254.169.254]:80", true},
+		{"[64:ff9b::a9fe:a9fe]:80", true},
+		{"localhost:80", true},
+	}
+	for _, tt := range tests {
+		err := g.checkAddr(tt.addr)
+		if got := errors.Is(err, ErrBlockedAddress); got != tt.blocked {
+			t.Errorf("checkAddr(%s) = %v, want blocked %v", tt.addr, err, tt.blocked)
+		}
+	}
+}
+
+func TestGuardCheckUrl(t *testing.T) {
+	g, err := newGuard(GuardConfig{
+		Ports:      []int{80, 443, 8443},
+		AllowHosts: []string{"*.example.com", "files.org"},
+		DenyHosts:  []string{"internal.example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{"https://cdn.example.com/a.pdf", false},
+		{"http://files.org/a.pdf", false},
+		{"https://cdn.example.com:8443/a.pdf", false},
+		{"https://cdn.example.com:22/a.pdf", true},
+		{"ftp://files.org/a.pdf", true},
+		{"file:///etc/passwd", true},
+		{"https://internal.example.com/a.pdf", true},
+		{"https://INTERNAL.example.com/a.pdf", true},
+		{"https://other.org/a.pdf", true},
+		{"http:///a.pdf", true},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.checkUrl(u)
+		if got := errors.Is(err, ErrBlockedUrl); got != tt.blocked {
+			t.Errorf("checkUrl(%s) = %v, want blocked %v", tt.url, err, tt.blocked)
+		}
+	}
+}
+
+// Редирект с разрешенного адреса на внутренний блокируется до соединения
+func TestRedirectToPrivateAddressBlocked(t *testing.T) {
+	var redirected int
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected++
+		_, port, _ := net.SplitHostPort(r.Host)
+		http.Redirect(w, r, "http://127.0.0.2:"+port+"/secret.pdf", http.StatusFound)
+	}))
+	defer origin.Close()
+	originUrl, _ := url.Parse(origin.URL)
+	port, _ := strconv.Atoi(originUrl.Port())
+
+	logger := logster.New(zapcore.AddSync(io.Discard), logster.Config{Project: "test", Level: "error", Format: "text"})
+	d, err := NewDownloader(PoolConfig{
+		Guard: GuardConfig{AllowNets: []string{"127.0.0.1/32"}, Ports: []int{port}},
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(origin.URL + "/a.pdf")
+	resp, err := d.fetchers["http"].Fetch(context.Background(), FetchRequest{
+		Job: models.DownloadJob{TaskId: "task", Url: u.String()},
+		Url: u,
+	})
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	if redirected == 0 {
+		t.Fatal("origin was not requested")
+	}
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("fetch error %v, want %v", err, ErrBlockedAddress)
+	}
+	if class := errorClass(err); class != models.ErrorClassBlocked {
+		t.Errorf("error class %s, want %s", class, models.ErrorClassBlocked)
+	}
+}
@@ -0,0 +1,260 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Когда отправлять HEAD перед скачиванием
+const (
+	// HeadFallback - отправлять, но если сервер отвечает ошибкой, скачивать без него
+	HeadFallback = "fallback"
+	// HeadAlways - отправлять, ошибка HEAD - ошибка скачивания
+	HeadAlways = "always"
+	// HeadNever - не отправлять, размер и тип проверяются по заголовкам ответа на GET
+	HeadNever = "never"
+)
+
+// HostConfig - ограничения обращений к одному хосту. Нулевые поля в Hosts
+// берутся из HostDefaults, нулевые поля HostDefaults - без ограничения.
+type HostConfig struct {
+	// MaxConnections - сколько запросов к хосту может выполняться одновременно
+	MaxConnections int `yaml:"max_connections"`
+	// RPS - сколько запросов в секунду можно отправить хосту, Burst - запас запросов подряд
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+	// Head - fallback (по умолчанию), always или never
+	Head string `yaml:"head"`
+}
+
+func (c HostConfig) validate() error {
+	switch c.Head {
+	case "", HeadFallback, HeadAlways, HeadNever:
+		return nil
+	}
+	return fmt.Errorf("unsupported head mode %q", c.Head)
+}
+
+func (c HostConfig) merge(defaults HostConfig) HostConfig {
+	if c.MaxConnections == 0 {
+		c.MaxConnections = defaults.MaxConnections
+	}
+	if c.RPS == 0 {
+		c.RPS = defaults.RPS
+	}
+	if c.Burst == 0 {
+		c.Burst = defaults.Burst
+	}
+	if c.Burst <= 0 {
+		c.Burst = 1
+	}
+	if c.Head == "" {
+		c.Head = defaults.Head
+	}
+	if c.Head == "" {
+		c.Head = HeadFallback
+	}
+	return c
+}
+
+// hostKey - имя хоста для поиска настроек, без порта и в нижнем регистре
+func hostKey(req *http.Request) string {
+	return strings.ToLower(req.URL.Hostname())
+}
+
+// matchHost ищет настройку для хоста: сначала точное имя, затем маски вида *.example.com
+func matchHost[T any](items map[string]T, host string) (T, bool) {
+	if v, ok := items[host]; ok {
+		return v, true
+	}
+	for domain := host; ; {
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			break
+		}
+		if v, ok := items["*."+parent]; ok {
+			return v, true
+		}
+		domain = parent
+	}
+	var zero T
+	return zero, false
+}
+
+// hostStateTTL - через сколько удалить состояние хоста, к которому не обращались
+const hostStateTTL = time.Hour
+
+// hostState - занятые соединения и корзина токенов одного хоста
+type hostState struct {
+	conns    chan struct{}
+	rps      float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	users    int
+	lastUsed time.Time
+}
+
+// hostLimiter ограничивает число одновременных запросов и частоту запросов к каждому хосту
+type hostLimiter struct {
+	defaults HostConfig
+	hosts    map[string]HostConfig
+
+	mu     sync.Mutex
+	states map[string]*hostState
+}
+
+func newHostLimiter(defaults HostConfig, hosts map[string]HostConfig) (*hostLimiter, error) {
+	err := defaults.validate()
+	if err != nil {
+		return nil, err
+	}
+	normalized := make(map[string]HostConfig, len(hosts))
+	for host, cfg := range hosts {
+		err = cfg.validate()
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %w", host, err)
+		}
+		normalized[strings.ToLower(host)] = cfg
+	}
+	return &hostLimiter{
+		defaults: defaults,
+		hosts:    normalized,
+		states:   make(map[string]*hostState),
+	}, nil
+}
+
+// config - настройки хоста с учетом значений по умолчанию
+func (l *hostLimiter) config(host string) HostConfig {
+	override, _ := matchHost(l.hosts, host)
+	return override.merge(l.defaults)
+}
+
+// state возвращает состояние хоста и отмечает, что им пользуются, done нужно вызвать по завершении
+func (l *hostLimiter) state(host string) (*hostState, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for h, st := range l.states {
+		if st.users == 0 && now.Sub(st.lastUsed) > hostStateTTL {
+			delete(l.states, h)
+		}
+	}
+	st, ok := l.states[host]
+	if !ok {
+		cfg := l.config(host)
+		st = &hostState{
+			rps:    cfg.RPS,
+			burst:  float64(cfg.Burst),
+			tokens: float64(cfg.Burst),
+			last:   now,
+		}
+		if cfg.MaxConnections > 0 {
+			st.conns = make(chan struct{}, cfg.MaxConnections)
+		}
+		l.states[host] = st
+	}
+	st.users++
+	st.lastUsed = now
+
+	done := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		st.users--
+		st.lastUsed = time.Now()
+	}
+	return st, done
+}
+
+// wait ждет свободный токен в корзине хоста
+func (l *hostLimiter) wait(ctx context.Context, st *hostState) error {
+	if st.rps <= 0 {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		st.tokens = min(st.burst, st.tokens+now.Sub(st.last).Seconds()*st.rps)
+		st.last = now
+		if st.tokens >= 1 {
+			st.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - st.tokens) / st.rps * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// acquire занимает соединение с хостом и токен, release освобождает соединение
+func (l *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	st, done := l.state(host)
+	if st.conns != nil {
+		select {
+		case <-ctx.Done():
+			done()
+			return nil, ctx.Err()
+		case st.conns <- struct{}{}:
+		}
+	}
+	release := func() {
+		if st.conns != nil {
+			<-st.conns
+		}
+		done()
+	}
+
+	err := l.wait(ctx, st)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// hostTransport применяет ограничения хоста к каждому запросу, включая редиректы.
+// Соединение считается занятым, пока не закрыто тело ответа.
+type hostTransport struct {
+	next   http.RoundTripper
+	limits *hostLimiter
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limits.acquire(req.Context(), hostKey(req))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+var (
+	ErrTooLarge     = errors.New("file too large")
+	ErrTaskTooLarge = errors.New("task size budget exceeded")
+)
+
+// taskUsage - сколько байт уже скачано для задачи
+type taskUsage struct {
+	bytes    int64
+	lastUsed time.Time
+}
+
+func tooLarge(err error, format string, args ...interface{}) error {
+	return classed(models.ErrorClassTooLarge, fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...)))
+}
+
+// checkSize проверяет заявленный сервером размер файла до начала скачивания.
+// offset - сколько байт уже скачано, size - сколько осталось, size < 0 - размер неизвестен
+func (d *Downloader) checkSize(taskId string, offset, size int64) error {
+	if size < 0 {
+		return nil
+	}
+	if d.maxFileSize > 0 && offset+size > d.maxFileSize {
+		return tooLarge(ErrTooLarge, "file size %d exceeds maximum allowed size %d", offset+size, d.maxFileSize)
+	}
+	if d.maxTaskSize > 0 {
+		var used int64
+		d.mu.Lock()
+		if u, ok := d.usage[taskId]; ok {
+			used = u.bytes
+		}
+		d.mu.Unlock()
+		if used+size > d.maxTaskSize {
+			return tooLarge(ErrTaskTooLarge, "task already has %d bytes, file of %d bytes exceeds budget %d", used, size, d.maxTaskSize)
+		}
+	}
+	return nil
+}
+
+// reserve учитывает n скачанных байт задачи, превышение бюджета задачи - ошибка
+func (d *Downloader) reserve(taskId string, n int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for id, u := range d.usage {
+		if _, active := d.inflight[id]; !active && now.Sub(u.lastUsed) > taskUsageTTL {
+			delete(d.usage, id)
+		}
+	}
+	u, ok := d.usage[taskId]
+	if !ok {
+		u = &taskUsage{}
+		d.usage[taskId] = u
+	}
+	u.bytes += n
+	u.lastUsed = now
+	if d.maxTaskSize > 0 && u.bytes > d.maxTaskSize {
+		return tooLarge(ErrTaskTooLarge, "task size exceeds budget %d", d.maxTaskSize)
+	}
+	return nil
+}
+
+// release возвращает в бюджет задачи байты неудачной попытки
+func (d *Downloader) release(taskId string, n int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if u, ok := d.usage[taskId]; ok {
+		u.bytes -= n
+	}
+}
+
+// limitReader прерывает чтение тела ответа, как только превышен размер файла
+// или бюджет задачи, не полагаясь на заголовок Content-Length.
+// offset - сколько байт файла уже скачано предыдущими попытками.
+type limitReader struct {
+	d      *Downloader
+	r      io.Reader
+	taskId string
+	offset int64
+	read   int64
+}
+
+func (d *Downloader) limitBody(taskId string, offset int64, r io.Reader) *limitReader {
+	return &limitReader{d: d, r: r, taskId: taskId, offset: offset}
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if n > 0 {
+		l.read += int64(n)
+		if l.d.maxFileSize > 0 && l.offset+l.read > l.d.maxFileSize {
+			return n, tooLarge(ErrTooLarge, "file exceeds maximum allowed size %d", l.d.maxFileSize)
+		}
+		reserveErr := l.d.reserve(l.taskId, int64(n))
+		if reserveErr != nil {
+			return n, reserveErr
+		}
+	}
+	return n, err
+}
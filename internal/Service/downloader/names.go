@@ -0,0 +1,134 @@
+package downloader
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultFileName = "unnamed_file"
+	// maxNameLength - длина имени файла в байтах, которую допускают распространенные файловые системы
+	maxNameLength = 255
+)
+
+// preferredExt - расширения для типов, у которых mime.ExtensionsByType выдает
+// несколько вариантов не в том порядке
+var preferredExt = map[string]string{
+	"application/pdf":  ".pdf",
+	"application/zip":  ".zip",
+	"application/gzip": ".gz",
+	"application/json": ".json",
+	"image/jpeg":       ".jpg",
+	"image/png":        ".png",
+	"image/gif":        ".gif",
+	"image/webp":       ".webp",
+	"image/tiff":       ".tif",
+	"text/plain":       ".txt",
+	"text/html":        ".html",
+	"text/csv":         ".csv",
+}
+
+// scriptExt - расширения адресов, которые отдают файлы, но не являются их расширением
+var scriptExt = map[string]struct{}{
+	".php": {}, ".asp": {}, ".aspx": {}, ".jsp": {}, ".cgi": {}, ".pl": {}, ".do": {}, ".action": {},
+}
+
+// entryName выбирает имя файла в архиве: предложенное источником (Content-Disposition),
+// иначе из адреса после редиректов. Расширение подбирается по типу, определенному по содержимому.
+func entryName(sourceName, finalUrl, mimeType string) string {
+	if sourceName != "" {
+		// имя от источника оставляем как есть, только добавляем расширение, если его нет
+		if path.Ext(sourceName) == "" {
+			sourceName += typeExt(mimeType)
+		}
+		return sanitizeName(sourceName)
+	}
+
+	var name string
+	if u, err := url.Parse(finalUrl); err == nil && u.Path != "" {
+		name = path.Base(u.Path)
+	}
+	if name == "." || name == "/" {
+		name = ""
+	}
+	name = sanitizeName(name)
+	ext := strings.ToLower(path.Ext(name))
+	_, script := scriptExt[ext]
+	switch {
+	case name == defaultFileName:
+		name += typeExt(mimeType)
+	case script:
+		name = strings.TrimSuffix(name, path.Ext(name)) + typeExt(mimeType)
+	case ext == "":
+		name += typeExt(mimeType)
+	case mimeType != genericType && extensionType(name) != "" && !compatible(extensionType(name), mimeType):
+		// расширение в адресе противоречит содержимому
+		name = strings.TrimSuffix(name, path.Ext(name)) + typeExt(mimeType)
+	}
+	return sanitizeName(name)
+}
+
+// dispositionName достает имя файла из Content-Disposition, filename* по RFC 5987
+// mime.ParseMediaType раскодирует сам и отдает его как filename
+func dispositionName(value string) string {
+	if value == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// typeExt - расширение для типа файла, пустая строка - тип неизвестен
+func typeExt(mimeType string) string {
+	if mimeType == "" || mimeType == genericType {
+		return ""
+	}
+	if ext, ok := preferredExt[mimeType]; ok {
+		return ext
+	}
+	exts, err := mime.ExtensionsByType(mimeType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
+
+// sanitizeName убирает из имени разделители путей и управляющие символы, чтобы файл
+// не мог выйти за пределы архива, и обрезает слишком длинное имя, сохраняя расширение
+func sanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\':
+			return '_'
+		case r == utf8.RuneError || unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return defaultFileName
+	}
+
+	if len(name) > maxNameLength {
+		ext := path.Ext(name)
+		if len(ext) > maxNameLength/2 {
+			ext = ""
+		}
+		base := strings.TrimSuffix(name, ext)
+		cut := maxNameLength - len(ext)
+		// не разрезаем многобайтный символ
+		for cut > 0 && !utf8.RuneStart(base[cut]) {
+			cut--
+		}
+		name = base[:cut] + ext
+	}
+	return name
+}
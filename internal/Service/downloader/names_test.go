@@ -0,0 +1,45 @@
+package downloader
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report 2025.pdf", "report 2025.pdf"},
+		{"../../etc/passwd", "_.._etc_passwd"},
+		{"/etc/passwd", "_etc_passwd"},
+		{"dir\\..\\evil.exe", "dir_.._evil.exe"},
+		{"bad\x00\r\nname.pdf", "badname.pdf"},
+		{"a\xffb.pdf", "ab.pdf"},
+		{".hidden", "hidden"},
+		{"  ..  ", defaultFileName},
+		{"..", defaultFileName},
+		{"", defaultFileName},
+	}
+	for _, tt := range tests {
+		if got := sanitizeName(tt.name); got != tt.want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeNameLength(t *testing.T) {
+	for _, base := range []string{strings.Repeat("a", 300), strings.Repeat("отчет", 100)} {
+		got := sanitizeName(base + ".pdf")
+		if len(got) > maxNameLength {
+			t.Errorf("name length %d exceeds %d", len(got), maxNameLength)
+		}
+		if !strings.HasSuffix(got, ".pdf") {
+			t.Errorf("extension lost: %q", got)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("name is not valid utf-8: %q", got)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+const defaultMaxRedirects = 10
+
+var ErrRedirect = errors.New("redirect is not allowed")
+
+// RedirectConfig - какие редиректы выполнять. MaxRedirects 0 - по умолчанию 10, меньше нуля - не выполнять.
+type RedirectConfig struct {
+	MaxRedirects int `yaml:"max_redirects"`
+	// ForbidDowngrade - не переходить с https на http, SameHost - не уходить на другой хост
+	ForbidDowngrade bool `yaml:"forbid_downgrade"`
+	SameHost        bool `yaml:"same_host"`
+}
+
+type redirectPolicy struct {
+	maxRedirects    int
+	forbidDowngrade bool
+	sameHost        bool
+}
+
+func newRedirectPolicy(cfg RedirectConfig) redirectPolicy {
+	p := redirectPolicy{
+		maxRedirects:    cfg.MaxRedirects,
+		forbidDowngrade: cfg.ForbidDowngrade,
+		sameHost:        cfg.SameHost,
+	}
+	switch {
+	case p.maxRedirects == 0:
+		p.maxRedirects = defaultMaxRedirects
+	case p.maxRedirects < 0:
+		p.maxRedirects = 0
+	}
+	return p
+}
+
+func redirectError(format string, args ...interface{}) error {
+	return classed(models.ErrorClassRedirect, fmt.Errorf("%w: %s", ErrRedirect, fmt.Sprintf(format, args...)))
+}
+
+// check - CheckRedirect для http.Client, via - уже выполненные запросы начиная с исходного
+func (p redirectPolicy) check(req *http.Request, via []*http.Request) error {
+	if len(via) > p.maxRedirects {
+		return redirectError("stopped after %d redirects", p.maxRedirects)
+	}
+	prev := via[len(via)-1]
+	if p.forbidDowngrade && prev.URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return redirectError("downgrade from %s to %s", prev.URL.Redacted(), req.URL.Redacted())
+	}
+	if p.sameHost && !strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) {
+		return redirectError("%s leads to other host %s", via[0].URL.Hostname(), req.URL.Hostname())
+	}
+	return nil
+}
+
+// redirectChain восстанавливает по ответу цепочку редиректов: адреса, ответившие редиректом, по порядку.
+// Пароли из адресов, в том числе пришедших в Location, не сохраняются.
+func redirectChain(resp *http.Response) []models.Redirect {
+	var chain []models.Redirect
+	for r := resp.Request.Response; r != nil; r = r.Request.Response {
+		chain = append(chain, models.Redirect{Url: r.Request.URL.Redacted(), StatusCode: r.StatusCode})
+	}
+	slices.Reverse(chain)
+	return chain
+}
+
+// errorResponse - ответ для ошибки запроса. Если ошибку вернул CheckRedirect, http.Client
+// отдает последний ответ с редиректом, по нему видно, куда дошла цепочка.
+func errorResponse(resp *http.Response) *FetchResponse {
+	if resp == nil {
+		return nil
+	}
+	result := &FetchResponse{
+		FinalUrl:   resp.Request.URL.Redacted(),
+		StatusCode: resp.StatusCode,
+		Redirects:  redirectChain(resp),
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices && resp.StatusCode < http.StatusBadRequest {
+		result.Redirects = append(result.Redirects, models.Redirect{Url: result.FinalUrl, StatusCode: resp.StatusCode})
+	}
+	return result
+}
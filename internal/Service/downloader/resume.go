@@ -0,0 +1,98 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+var ErrRangeMismatch = errors.New("range response does not match partial file")
+
+// partial - недокачанный временный файл ссылки, который переживает попытки скачивания.
+// validator - ETag или Last-Modified ответа, пустой, если сервер не умеет отдавать диапазоны.
+// reserved - сколько байт файла учтено в бюджете задачи.
+type partial struct {
+	path      string
+	size      int64
+	reserved  int64
+	validator string
+	// detached - файлом владеет горутина незавершенной попытки, она же его и удалит
+	detached bool
+}
+
+// resumable сообщает, можно ли докачать файл следующей попыткой
+func (p *partial) resumable() bool {
+	return p.size > 0 && p.validator != ""
+}
+
+// rangeValidator возвращает валидатор для If-Range, если сервер поддерживает диапазоны.
+// Слабый ETag для If-Range не подходит, тогда используется Last-Modified.
+func rangeValidator(resp *http.Response) string {
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		return ""
+	}
+	etag := resp.Header.Get("ETag")
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// parseContentRange возвращает первый байт из заголовка вида "bytes 100-999/1000"
+func parseContentRange(value string) (int64, error) {
+	spec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("%w: bad content range %q", ErrRangeMismatch, value)
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, fmt.Errorf("%w: bad content range %q", ErrRangeMismatch, value)
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad content range %q", ErrRangeMismatch, value)
+	}
+	return n, nil
+}
+
+// rangeError - сервер прислал не тот диапазон, следующая попытка скачает файл заново
+func rangeError(err error) error {
+	return &DownloadError{Class: models.ErrorClassHttpStatus, Err: err, Transient: true}
+}
+
+// resetPartial выбрасывает недокачанный файл и возвращает его байты в бюджет задачи
+func (d *Downloader) resetPartial(taskId string, part *partial) {
+	if part.path != "" {
+		os.Remove(part.path)
+	}
+	d.release(taskId, part.reserved)
+	part.path = ""
+	part.size = 0
+	part.reserved = 0
+	part.validator = ""
+}
+
+// dropPartial удаляет недокачанный файл после последней неудачной попытки
+func (d *Downloader) dropPartial(taskId string, part *partial) {
+	if part.detached {
+		return
+	}
+	d.resetPartial(taskId, part)
+}
+
+// hashFile считает суммы файла целиком, нужен после докачки
+func hashFile(path string, hash io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(hash, file)
+	return err
+}
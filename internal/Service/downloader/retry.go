@@ -0,0 +1,161 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBaseBackoff = 500 * time.Millisecond
+	defaultMaxBackoff  = 30 * time.Second
+)
+
+var defaultRetryableStatuses = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooEarly,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+type RetryConfig struct {
+	// MaxAttempts - число попыток скачивания ссылки, включая первую
+	MaxAttempts int           `yaml:"max_attempts"`
+	BaseBackoff time.Duration `yaml:"base_backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+	// Jitter - доля паузы от 0 до 1, на которую она случайно уменьшается
+	Jitter            float64 `yaml:"jitter"`
+	RetryableStatuses []int   `yaml:"retryable_statuses"`
+}
+
+type retryPolicy struct {
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	jitter      float64
+	statuses    map[int]struct{}
+}
+
+func newRetryPolicy(cfg RetryConfig) retryPolicy {
+	policy := retryPolicy{
+		maxAttempts: cfg.MaxAttempts,
+		baseBackoff: cfg.BaseBackoff,
+		maxBackoff:  cfg.MaxBackoff,
+		jitter:      math.Min(math.Max(cfg.Jitter, 0), 1),
+		statuses:    make(map[int]struct{}),
+	}
+	if policy.maxAttempts <= 0 {
+		policy.maxAttempts = defaultMaxAttempts
+	}
+	if policy.baseBackoff <= 0 {
+		policy.baseBackoff = defaultBaseBackoff
+	}
+	if policy.maxBackoff <= 0 {
+		policy.maxBackoff = defaultMaxBackoff
+	}
+	statuses := cfg.RetryableStatuses
+	if len(statuses) == 0 {
+		statuses = defaultRetryableStatuses
+	}
+	for _, code := range statuses {
+		policy.statuses[code] = struct{}{}
+	}
+	return policy
+}
+
+// backoff - пауза перед попыткой attempt+1: экспоненциальная с jitter, ограниченная maxBackoff,
+// но не меньше Retry-After ответа сервера. Retry-After больше maxBackoff сюда не доходит:
+// statusError делает такую ошибку окончательной.
+func (p retryPolicy) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	delay := p.baseBackoff << (attempt - 1)
+	if delay <= 0 || delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+	delay -= time.Duration(rand.Float64() * p.jitter * float64(delay))
+	return max(delay, retryAfter)
+}
+
+// statusError - ошибка для ответа с неуспешным кодом, временная, если код есть в списке повторяемых
+// и сервер не просит ждать дольше maxBackoff
+func (p retryPolicy) statusError(resp *http.Response, err error) error {
+	_, transient := p.statuses[resp.StatusCode]
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+	if transient && retryAfter > p.maxBackoff {
+		transient = false
+		err = fmt.Errorf("%w, retry after %s exceeds max backoff %s", err, retryAfter, p.maxBackoff)
+	}
+	return &DownloadError{
+		Class:      models.ErrorClassHttpStatus,
+		Err:        err,
+		Transient:  transient,
+		RetryAfter: retryAfter,
+	}
+}
+
+// parseRetryAfter разбирает заголовок Retry-After в секундах или в виде HTTP даты
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// isTransient определяет, имеет ли смысл повторить попытку после ошибки
+func isTransient(err error) bool {
+	var downloadErr *DownloadError
+	if errors.As(err, &downloadErr) {
+		return downloadErr.Transient
+	}
+	if errors.Is(err, ErrTaskCancelled) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && urlErr.Timeout() {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+func retryAfterOf(err error) time.Duration {
+	var downloadErr *DownloadError
+	if errors.As(err, &downloadErr) {
+		return downloadErr.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,209 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+// Политики выбора типа файла, когда заявленный сервером тип расходится с содержимым
+const (
+	// TypePolicyDetected - тип определяется по содержимому, заявленный используется,
+	// только если содержимое не распознано, а у заявленного типа нет сигнатуры
+	TypePolicyDetected = "detected"
+	// TypePolicyStrict - как detected, но расхождение с Content-Type или расширением в URL - ошибка
+	TypePolicyStrict = "strict"
+	// TypePolicyDeclared - доверять Content-Type, как раньше
+	TypePolicyDeclared = "declared"
+)
+
+// sniffLen - сколько первых байт файла смотрится для определения типа
+const sniffLen = 512
+
+const genericType = "application/octet-stream"
+
+var utf8BOM = []byte("\xEF\xBB\xBF")
+
+var ErrTypeMismatch = errors.New("content type mismatch")
+
+// signature - сигнатура формата, которую не знает http.DetectContentType
+type signature struct {
+	offset   int
+	magic    []byte
+	mimeType string
+}
+
+var signatures = []signature{
+	{0, []byte("II*\x00"), "image/tiff"},
+	{0, []byte("MM\x00*"), "image/tiff"},
+	{4, []byte("ftypheic"), "image/heic"},
+	{4, []byte("ftypavif"), "image/avif"},
+	{0, []byte("7z\xBC\xAF\x27\x1C"), "application/x-7z-compressed"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{0, []byte("\xFD7zXZ\x00"), "application/x-xz"},
+}
+
+// signedTypes - типы, которые http.DetectContentType распознает по сигнатуре
+var signedTypes = map[string]struct{}{
+	"application/pdf": {}, "application/postscript": {}, "application/zip": {}, "application/x-gzip": {},
+	"application/gzip": {}, "application/x-rar-compressed": {}, "application/wasm": {}, "application/ogg": {},
+	"application/vnd.ms-fontobject": {}, "image/png": {}, "image/jpeg": {}, "image/gif": {}, "image/webp": {},
+	"image/bmp": {}, "image/x-icon": {}, "audio/mpeg": {}, "audio/wave": {}, "audio/aiff": {}, "audio/basic": {},
+	"audio/midi": {}, "video/mp4": {}, "video/webm": {}, "video/avi": {}, "font/ttf": {}, "font/otf": {},
+	"font/collection": {}, "font/woff": {}, "font/woff2": {},
+}
+
+// hasSignature - файл этого типа начинается с известной сигнатуры, и если ее нет,
+// содержимое не того типа
+func hasSignature(mimeType string) bool {
+	if _, ok := signedTypes[mimeType]; ok {
+		return true
+	}
+	for _, s := range signatures {
+		if s.mimeType == mimeType {
+			return true
+		}
+	}
+	// офисные форматы и jar - zip архивы
+	return mimeType != "" && mimeType != genericType && compatible(mimeType, "application/zip")
+}
+
+// detectType определяет тип файла по первым байтам
+func detectType(head []byte) string {
+	for _, s := range signatures {
+		if len(head) >= s.offset+len(s.magic) && bytes.Equal(head[s.offset:s.offset+len(s.magic)], s.magic) {
+			return s.mimeType
+		}
+	}
+	// DetectContentType не пропускает пробелы и BOM перед заголовком PDF. Заголовок
+	// дальше в файле не считается: так выглядит и страница с ошибкой, где упомянут PDF.
+	if bytes.HasPrefix(bytes.TrimLeft(bytes.TrimPrefix(head, utf8BOM), " \t\r\n\f"), []byte("%PDF-")) {
+		return "application/pdf"
+	}
+	return mediaType(http.DetectContentType(head))
+}
+
+// mediaType отбрасывает параметры вида charset, пустая строка - тип не указан или не разобран
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mimeType
+}
+
+// extensionType - тип по расширению файла в URL, пустая строка - расширение неизвестно
+func extensionType(rawUrl string) string {
+	ext := path.Ext(strings.SplitN(rawUrl, "?", 2)[0])
+	if ext == "" {
+		return ""
+	}
+	return mediaType(mime.TypeByExtension(strings.ToLower(ext)))
+}
+
+// compatible сообщает, не противоречит ли заявленный тип обнаруженному.
+// Общие типы не противоречат ничему, а zip - контейнер для офисных форматов.
+func compatible(declared, detected string) bool {
+	switch {
+	case declared == "" || detected == "" || declared == detected:
+		return true
+	case detected == genericType || declared == genericType:
+		return true
+	case detected == "text/plain" && strings.HasPrefix(declared, "text/"):
+		return true
+	case detected == "application/zip":
+		return strings.HasSuffix(declared, "+zip") ||
+			strings.HasPrefix(declared, "application/vnd.openxmlformats-") ||
+			strings.HasPrefix(declared, "application/vnd.oasis.opendocument.") ||
+			declared == "application/java-archive"
+	}
+	return false
+}
+
+// declaredAllowed проверяет по заявленному типу, может ли файл оказаться допустимым, до чтения тела.
+// При политике detected тип определяет содержимое, и заголовков для отказа недостаточно.
+func declaredAllowed(policy, declared string, allowedTypes []string) bool {
+	switch {
+	case declared == "" || declared == genericType || policy == TypePolicyDetected:
+		return true
+	case policy == TypePolicyDeclared:
+		return slices.Contains(allowedTypes, declared)
+	}
+	// strict: обнаруженный тип должен быть допустимым и совместимым с заявленным
+	for _, t := range allowedTypes {
+		if compatible(declared, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveType выбирает тип файла, к которому применяется список допустимых типов
+func resolveType(policy, declared, detected, fromExt string) (string, error) {
+	if policy == TypePolicyDeclared {
+		if declared != "" {
+			return declared, nil
+		}
+		return detected, nil
+	}
+
+	if policy == TypePolicyStrict {
+		if !compatible(declared, detected) {
+			return "", fmt.Errorf("%w: declared %s, detected %s", ErrTypeMismatch, declared, detected)
+		}
+		if !compatible(fromExt, detected) {
+			return "", fmt.Errorf("%w: url extension means %s, detected %s", ErrTypeMismatch, fromExt, detected)
+		}
+	}
+	// содержимое не распознано или распознано только как текст - уточняем заявленным типом,
+	// если его нельзя проверить по сигнатуре
+	if (detected == genericType || detected == "text/plain") && declared != "" {
+		if hasSignature(declared) {
+			return "", fmt.Errorf("%w: declared %s, but content has no matching signature", ErrTypeMismatch, declared)
+		}
+		if compatible(declared, detected) {
+			return declared, nil
+		}
+	}
+	return detected, nil
+}
+
+// sniffHead возвращает первые байты файла. При докачке начало файла уже лежит
+// в недокачанном файле, а тело ответа - его продолжение.
+func sniffHead(body *bufio.Reader, part *partial, resume bool) []byte {
+	head := make([]byte, 0, sniffLen)
+	if resume {
+		file, err := os.Open(part.path)
+		if err == nil {
+			n, _ := io.ReadFull(file, head[:sniffLen])
+			head = head[:n]
+			file.Close()
+		}
+	}
+	if len(head) < sniffLen {
+		// Peek вернет меньше байт, если файл короче, ошибку тогда выдаст чтение тела
+		peeked, _ := body.Peek(sniffLen - len(head))
+		head = append(head, peeked...)
+	}
+	return head
+}
+
+func typeError(err error) error {
+	if errors.Is(err, ErrTypeMismatch) {
+		return classed(models.ErrorClassTypeMismatch, err)
+	}
+	return classed(models.ErrorClassType, err)
+}
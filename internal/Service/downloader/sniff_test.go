@@ -0,0 +1,90 @@
+package downloader
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectType(t *testing.T) {
+	tests := []struct {
+		head string
+		want string
+	}{
+		{"%PDF-1.7\n", "application/pdf"},
+		{"\xEF\xBB\xBF%PDF-1.4", "application/pdf"},
+		{"\r\n  %PDF-1.4", "application/pdf"},
+		{"<html><body>file %PDF-1.4 not found</body></html>", "text/html"},
+		{"error: %PDF-1.4 missing", "text/plain"},
+		{"\x89PNG\r\n\x1a\n", "image/png"},
+		{"II*\x00", "image/tiff"},
+		{"\x00\x01\x02\x03\xfe\xff", genericType},
+	}
+	for _, tt := range tests {
+		if got := detectType([]byte(tt.head)); got != tt.want {
+			t.Errorf("detectType(%q) = %s, want %s", tt.head, got, tt.want)
+		}
+	}
+}
+
+func TestResolveType(t *testing.T) {
+	const (
+		pdf  = "application/pdf"
+		png  = "image/png"
+		html = "text/html"
+		text = "text/plain"
+		csv  = "text/csv"
+		docx = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		zip  = "application/zip"
+	)
+	// want пустой - ожидается ErrTypeMismatch
+	tests := []struct {
+		policy   string
+		declared string
+		detected string
+		fromExt  string
+		want     string
+	}{
+		// detected: тип по содержимому
+		{TypePolicyDetected, pdf, pdf, "", pdf},
+		{TypePolicyDetected, "", pdf, "", pdf},
+		{TypePolicyDetected, html, pdf, "", pdf},
+		{TypePolicyDetected, pdf, html, "", html},
+		{TypePolicyDetected, zip, docx, "", docx},
+		{TypePolicyDetected, docx, zip, "", zip},
+		// содержимое не распознано: заявленный тип без сигнатуры принимается
+		{TypePolicyDetected, csv, text, "", csv},
+		{TypePolicyDetected, "application/x-custom", genericType, "", "application/x-custom"},
+		{TypePolicyDetected, "", genericType, "", genericType},
+		{TypePolicyDetected, genericType, genericType, "", genericType},
+		// а заявленный тип с сигнатурой, которой нет в содержимом, - нет
+		{TypePolicyDetected, pdf, genericType, "", ""},
+		{TypePolicyDetected, pdf, text, "", ""},
+		{TypePolicyDetected, png, genericType, "", ""},
+		{TypePolicyDetected, docx, genericType, "", ""},
+		// strict: расхождение с Content-Type или расширением - ошибка
+		{TypePolicyStrict, pdf, pdf, pdf, pdf},
+		{TypePolicyStrict, html, pdf, "", ""},
+		{TypePolicyStrict, pdf, pdf, png, ""},
+		{TypePolicyStrict, "", pdf, pdf, pdf},
+		{TypePolicyStrict, genericType, pdf, "", pdf},
+		{TypePolicyStrict, pdf, genericType, "", ""},
+		// declared: доверяем заголовку
+		{TypePolicyDeclared, pdf, genericType, "", pdf},
+		{TypePolicyDeclared, pdf, html, "", pdf},
+		{TypePolicyDeclared, "", png, "", png},
+	}
+	for _, tt := range tests {
+		got, err := resolveType(tt.policy, tt.declared, tt.detected, tt.fromExt)
+		if tt.want == "" {
+			if !errors.Is(err, ErrTypeMismatch) {
+				t.Errorf("%s: declared %q, detected %q, ext %q: got %q, %v, want mismatch",
+					tt.policy, tt.declared, tt.detected, tt.fromExt, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: declared %q, detected %q, ext %q: got %q, %v, want %q",
+				tt.policy, tt.declared, tt.detected, tt.fromExt, got, err, tt.want)
+		}
+	}
+}
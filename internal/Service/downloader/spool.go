@@ -0,0 +1,90 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+const defaultSpoolDir = "./tmp/spool/"
+
+// PrepareSpool создает директорию для временных файлов загрузок и удаляет файлы,
+// оставшиеся от прошлого запуска
+func (d *Downloader) PrepareSpool() error {
+	err := os.MkdirAll(d.spoolDir, 0775)
+	if err != nil {
+		return fmt.Errorf("create spool dir %s failed: %w", d.spoolDir, err)
+	}
+	entries, err := os.ReadDir(d.spoolDir)
+	if err != nil {
+		return fmt.Errorf("read spool dir %s failed: %w", d.spoolDir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		err = os.Remove(filepath.Join(d.spoolDir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("remove spool file %s failed: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// spoolBody пишет тело ответа во временный файл, по пути считая размер, sha256
+// и сумму алгоритмом algo, если клиент передал ожидаемую сумму. При resume тело дописывается в конец недокачанного файла, тогда хеш считается
+// по всему файлу заново. При ошибке файл остается, решение о докачке принимает вызывающий.
+// Готовый файл удаляет менеджер архивов после того, как положит его в архив.
+func (d *Downloader) spoolBody(body io.Reader, result *models.ZipJob, part *partial, resume bool, algo string) error {
+	if part.path == "" {
+		file, err := os.CreateTemp(d.spoolDir, "job-*")
+		if err != nil {
+			return classed(models.ErrorClassInternal, fmt.Errorf("create spool file failed: %w", err))
+		}
+		file.Close()
+		part.path = file.Name()
+	}
+	flags := os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY | os.O_APPEND
+	} else {
+		part.size = 0
+	}
+	file, err := os.OpenFile(part.path, flags, 0)
+	if err != nil {
+		return classed(models.ErrorClassInternal, fmt.Errorf("open spool file failed: %w", err))
+	}
+
+	hashes := newSpoolHashes(algo)
+	n, err := io.Copy(io.MultiWriter(file, hashes), body)
+	part.size += n
+	closeErr := file.Close()
+	if err == nil && closeErr != nil {
+		err = classed(models.ErrorClassInternal, fmt.Errorf("close spool file failed: %w", closeErr))
+	}
+	if err != nil {
+		return err
+	}
+
+	if resume {
+		hashes.Reset()
+		err = hashFile(part.path, hashes)
+		if err != nil {
+			return classed(models.ErrorClassInternal, fmt.Errorf("hash spool file failed: %w", err))
+		}
+	}
+	result.SpoolPath = part.path
+	result.Size = part.size
+	hashes.result(result)
+	return nil
+}
+
+// removeSpool удаляет временный файл загрузки, если он есть
+func removeSpool(path string) {
+	if path != "" {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,145 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+const (
+	defaultDialTimeout           = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = 30 * time.Second
+	defaultBodyIdleTimeout       = time.Minute
+)
+
+// net/http не экспортирует ошибки своих таймаутов, их можно отличить только по тексту
+const (
+	tlsTimeoutText    = "TLS handshake timeout"
+	headerTimeoutText = "timeout awaiting response headers"
+)
+
+var (
+	ErrDialTimeout   = errors.New("dial timeout")
+	ErrTLSTimeout    = errors.New("tls handshake timeout")
+	ErrHeaderTimeout = errors.New("response header timeout")
+	ErrIdleTimeout   = errors.New("body idle timeout")
+	ErrTotalTimeout  = errors.New("total timeout")
+)
+
+// TimeoutConfig - таймауты скачивания ссылки, незаданные берутся по умолчанию.
+// Total по умолчанию не ограничен.
+type TimeoutConfig struct {
+	// Dial - установка соединения, TLSHandshake - согласование TLS
+	Dial         time.Duration `yaml:"dial"`
+	TLSHandshake time.Duration `yaml:"tls_handshake"`
+	// ResponseHeader - ожидание заголовков ответа после отправки запроса
+	ResponseHeader time.Duration `yaml:"response_header"`
+	// BodyIdle - сколько можно ждать очередную порцию тела, медленная, но идущая загрузка не прерывается
+	BodyIdle time.Duration `yaml:"body_idle"`
+	// Total - на всю ссылку вместе с повторными попытками
+	Total time.Duration `yaml:"total"`
+}
+
+func (c TimeoutConfig) withDefaults() TimeoutConfig {
+	if c.Dial <= 0 {
+		c.Dial = defaultDialTimeout
+	}
+	if c.TLSHandshake <= 0 {
+		c.TLSHandshake = defaultTLSHandshakeTimeout
+	}
+	if c.ResponseHeader <= 0 {
+		c.ResponseHeader = defaultResponseHeaderTimeout
+	}
+	if c.BodyIdle <= 0 {
+		c.BodyIdle = defaultBodyIdleTimeout
+	}
+	return c
+}
+
+func timeoutError(class string, err error) error {
+	// общий таймаут исчерпан, повторять попытку бессмысленно
+	return &DownloadError{Class: class, Err: err, Transient: class != models.ErrorClassTimeoutTotal}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// timeoutDial - DialContext, который отличает таймаут соединения от отмены контекста
+func timeoutDial(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil && ctx.Err() == nil && isTimeout(err) {
+			return nil, timeoutError(models.ErrorClassTimeoutDial, fmt.Errorf("%w: %s after %s", ErrDialTimeout, address, dialer.Timeout))
+		}
+		return conn, err
+	}
+}
+
+// transportTimeout классифицирует таймауты http.Transport: согласование TLS и ожидание заголовков
+func transportTimeout(err error) error {
+	if err == nil || !isTimeout(err) {
+		return err
+	}
+	switch {
+	case strings.Contains(err.Error(), tlsTimeoutText):
+		return timeoutError(models.ErrorClassTimeoutTLS, fmt.Errorf("%w: %w", ErrTLSTimeout, err))
+	case strings.Contains(err.Error(), headerTimeoutText):
+		return timeoutError(models.ErrorClassTimeoutHeaders, fmt.Errorf("%w: %w", ErrHeaderTimeout, err))
+	}
+	return err
+}
+
+// contextTimeout заменяет ошибку, вызванную отменой контекста по таймауту, на ошибку этого таймаута
+func contextTimeout(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	cause := context.Cause(ctx)
+	switch {
+	case errors.Is(cause, ErrTotalTimeout):
+		return timeoutError(models.ErrorClassTimeoutTotal, cause)
+	case errors.Is(cause, ErrIdleTimeout):
+		return timeoutError(models.ErrorClassTimeoutIdle, cause)
+	}
+	return err
+}
+
+// idleBody прерывает чтение тела, если данные не приходят дольше timeout:
+// отменяет контекст попытки, от которого зависит соединение
+type idleBody struct {
+	io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newIdleBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelCauseFunc) *idleBody {
+	return &idleBody{
+		ReadCloser: body,
+		timeout:    timeout,
+		timer: time.AfterFunc(timeout, func() {
+			cancel(fmt.Errorf("%w: no data for %s", ErrIdleTimeout, timeout))
+		}),
+	}
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,306 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TransportConfig - как загрузчик выходит в сеть: прокси и настройки TLS
+type TransportConfig struct {
+	Proxy ProxyConfig `yaml:"proxy"`
+	TLS   TLSConfig   `yaml:"tls"`
+}
+
+type ProxyConfig struct {
+	// Url - адрес прокси вида http://, https:// или socks5://, учетные данные можно указать в адресе
+	Url string `yaml:"url"`
+	// NoProxy - куда ходить напрямую: хост, маска *.example.com или .example.com, IP или подсеть,
+	// к каждому можно добавить :port. "*" - прокси не используется
+	NoProxy []string `yaml:"no_proxy"`
+}
+
+// String скрывает пароль из адреса прокси: настройки целиком пишутся в лог при старте
+func (c ProxyConfig) String() string {
+	proxyUrl := "***"
+	if u, err := url.Parse(c.Url); err == nil {
+		proxyUrl = u.Redacted()
+	}
+	return fmt.Sprintf("{Url:%s NoProxy:%v}", proxyUrl, c.NoProxy)
+}
+
+type TLSConfig struct {
+	// CaFiles - PEM файлы с корневыми сертификатами, которые добавляются к системным
+	CaFiles []string `yaml:"ca_files"`
+	// MinVersion - минимальная версия TLS: 1.0, 1.1, 1.2 или 1.3, по умолчанию 1.2
+	MinVersion string `yaml:"min_version"`
+	// ClientCerts - сертификаты для хостов, которые требуют mTLS, поддерживаются маски вида *.example.com
+	ClientCerts map[string]ClientCertConfig `yaml:"client_certs"`
+}
+
+type ClientCertConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var proxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// viaProxyKey - отметка в контексте запроса, который proxyTransport отправил через прокси
+type viaProxyKey struct{}
+
+// newTransport собирает транспорт по настройкам. С guard соединения открываются только
+// с разрешенными адресами, кроме самого прокси: он задан в настройках и может быть внутренним.
+func newTransport(cfg TransportConfig, timeouts TimeoutConfig, g *guard) (http.RoundTripper, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSHandshakeTimeout = timeouts.TLSHandshake
+	base.ResponseHeaderTimeout = timeouts.ResponseHeader
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	base.TLSClientConfig = tlsConfig
+
+	proxy, err := newProxy(cfg.Proxy, g)
+	if err != nil {
+		return nil, err
+	}
+	if proxy != nil {
+		base.Proxy = viaProxy(proxy.url)
+	}
+	direct := timeoutDial(&net.Dialer{Timeout: timeouts.Dial, KeepAlive: 30 * time.Second})
+	base.DialContext = direct
+	if g != nil {
+		guarded := timeoutDial(g.dialer(timeouts.Dial))
+		base.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			// у запроса через прокси транспорт соединяется только с прокси
+			if ctx.Value(viaProxyKey{}) != nil {
+				return direct(ctx, network, address)
+			}
+			return guarded(ctx, network, address)
+		}
+	}
+
+	next, err := withClientCerts(base, cfg.TLS.ClientCerts)
+	if err != nil || proxy == nil {
+		return next, err
+	}
+	return &proxyTransport{next: next, proxy: proxy}, nil
+}
+
+// withClientCerts направляет запросы к хостам с клиентскими сертификатами в отдельные транспорты
+func withClientCerts(base *http.Transport, certs map[string]ClientCertConfig) (http.RoundTripper, error) {
+	if len(certs) == 0 {
+		return base, nil
+	}
+	router := &certRouter{
+		next:   base,
+		byHost: make(map[string]*http.Transport, len(certs)),
+	}
+	for host, certCfg := range certs {
+		cert, err := tls.LoadX509KeyPair(certCfg.CertFile, certCfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate for %s failed: %w", host, err)
+		}
+		transport := base.Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		router.byHost[strings.ToLower(host)] = transport
+	}
+	return router, nil
+}
+
+func newTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if cfg.MinVersion != "" {
+		version, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls version %q", cfg.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if len(cfg.CaFiles) == 0 {
+		return tlsConfig, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, file := range cfg.CaFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file failed: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in ca file %s", file)
+		}
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
+}
+
+// certRouter отправляет запросы к хостам с клиентскими сертификатами через
+// отдельные транспорты, у каждого свой пул соединений
+type certRouter struct {
+	next   http.RoundTripper
+	byHost map[string]*http.Transport
+}
+
+func (r *certRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := matchHost(r.byHost, hostKey(req)); ok {
+		return transport.RoundTrip(req)
+	}
+	return r.next.RoundTrip(req)
+}
+
+// proxy выбирает, идти ли запросу через прокси
+type proxy struct {
+	url   *url.URL
+	all   bool
+	rules []noProxyRule
+	nets  []netip.Prefix
+	guard *guard
+}
+
+// noProxyRule - правило NO_PROXY, port пустой - любой порт
+type noProxyRule struct {
+	host string
+	port string
+}
+
+func newProxy(cfg ProxyConfig, g *guard) (*proxy, error) {
+	if cfg.Url == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.Url)
+	if err != nil {
+		// url.Error содержит адрес целиком, вместе с паролем
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("parse proxy url failed: %w", err)
+	}
+	if !slices.Contains(proxySchemes, u.Scheme) || u.Hostname() == "" {
+		return nil, fmt.Errorf("unsupported proxy url %s", u.Redacted())
+	}
+	p := &proxy{
+		url:   u,
+		guard: g,
+	}
+	for _, entry := range cfg.NoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case entry == "*":
+			p.all = true
+		case strings.Contains(entry, "/"):
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("parse no_proxy net %s failed: %w", entry, err)
+			}
+			p.nets = append(p.nets, prefix)
+		default:
+			p.rules = append(p.rules, parseNoProxy(entry))
+		}
+	}
+	return p, nil
+}
+
+// parseNoProxy разбирает хост с необязательным портом, .example.com приводится к *.example.com
+func parseNoProxy(entry string) noProxyRule {
+	rule := noProxyRule{host: entry}
+	if host, port, err := net.SplitHostPort(entry); err == nil {
+		rule = noProxyRule{host: host, port: port}
+	}
+	rule.host = strings.Trim(rule.host, "[]")
+	if strings.HasPrefix(rule.host, ".") {
+		rule.host = "*" + rule.host
+	}
+	return rule
+}
+
+// bypass - адрес попадает под NO_PROXY. Маска *.example.com покрывает и сам example.com.
+func (p *proxy) bypass(u *url.URL) bool {
+	if p.all {
+		return true
+	}
+	host, port := strings.ToLower(u.Hostname()), effectivePort(u)
+	if ip, err := netip.ParseAddr(host); err == nil {
+		for _, prefix := range p.nets {
+			if prefix.Contains(ip.Unmap()) {
+				return true
+			}
+		}
+	}
+	for _, rule := range p.rules {
+		if rule.port != "" && rule.port != port {
+			continue
+		}
+		if rule.host == host {
+			return true
+		}
+		if domain, ok := strings.CutPrefix(rule.host, "*."); ok && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// route решает, идти ли запросу через прокси. Адрес назначения прокси разрешает сам, поэтому
+// имя разрешается и здесь, и все его адреса проверяются до отправки запроса.
+func (p *proxy) route(req *http.Request) (bool, error) {
+	if p.bypass(req.URL) {
+		return false, nil
+	}
+	if p.guard != nil {
+		err := p.guard.checkHost(req.Context(), req.URL.Hostname(), effectivePort(req.URL))
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// proxyTransport отмечает запросы, которые идут через прокси. По отметке http.Transport
+// берет адрес прокси, а DialContext открывает соединение с прокси без проверок guard.
+type proxyTransport struct {
+	next  http.RoundTripper
+	proxy *proxy
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	useProxy, err := t.proxy.route(req)
+	if err != nil {
+		return nil, err
+	}
+	if useProxy {
+		req = req.WithContext(context.WithValue(req.Context(), viaProxyKey{}, true))
+	}
+	return t.next.RoundTrip(req)
+}
+
+// viaProxy - функция Proxy для http.Transport, прокси получают только отмеченные запросы
+func viaProxy(proxyUrl *url.URL) func(req *http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if req.Context().Value(viaProxyKey{}) != nil {
+			return proxyUrl, nil
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,136 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+	"github.com/JonnyShabli/23.07.2025/internal/repository"
+	"github.com/JonnyShabli/23.07.2025/pkg/logster"
+)
+
+const (
+	defaultInterval = time.Minute
+	// defaultExpiredRetention - сколько хранится отметка Expired, если срок для нее не задан
+	defaultExpiredRetention = 24 * time.Hour
+)
+
+type JanitorInterface interface {
+	Start(ctx context.Context) error
+}
+
+type JanitorConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	// Retention - срок хранения задачи по статусу, отсчитывается от последнего изменения.
+	// Для статуса Expired это время, в течение которого на запросы отвечаем 410 Gone,
+	// после чего запись удаляется из хранилища, по умолчанию 24h.
+	Retention map[string]time.Duration `yaml:"retention"`
+}
+
+// Janitor периодически удаляет просроченные задачи и их архивы
+type Janitor struct {
+	interval  time.Duration
+	retention map[string]time.Duration
+	db        repository.StorageInterface
+	logger    logster.Logger
+}
+
+func NewJanitor(cfg JanitorConfig, db repository.StorageInterface, logger logster.Logger) *Janitor {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	retention := make(map[string]time.Duration, len(cfg.Retention)+1)
+	for status, ttl := range cfg.Retention {
+		retention[status] = ttl
+	}
+	// без срока для Expired просроченные записи копились бы в хранилище бесконечно
+	if _, ok := retention[models.StatusExpired]; !ok && len(retention) > 0 {
+		retention[models.StatusExpired] = defaultExpiredRetention
+	}
+	return &Janitor{
+		interval:  interval,
+		retention: retention,
+		db:        db,
+		logger:    logger.WithField("Layer", "Janitor"),
+	}
+}
+
+func (j *Janitor) Start(ctx context.Context) error {
+	if len(j.retention) == 0 {
+		j.logger.Infof("retention is not configured, janitor disabled")
+		return nil
+	}
+	j.logger.Infof("Starting janitor with interval %s", j.interval)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			j.logger.Infof("Stopping janitor")
+			return nil
+		case <-ticker.C:
+			err := j.collect(ctx)
+			if err != nil {
+				j.logger.WithError(err).Errorf("collect expired tasks failed")
+			}
+		}
+	}
+}
+
+func (j *Janitor) collect(ctx context.Context) error {
+	tasks, err := j.db.GetTasks(ctx)
+	if err != nil {
+		return fmt.Errorf("get tasks failed: %w", err)
+	}
+
+	now := time.Now()
+	var expired, deleted int
+	// ошибка с одной задачей логируется и не мешает обработать остальные
+	for _, task := range tasks {
+		ttl, ok := j.retention[task.Status]
+		if !ok || now.Sub(task.UpdatedAt) < ttl {
+			continue
+		}
+
+		// задача уже помечена как просроченная и срок хранения отметки вышел,
+		// архив удаляем еще раз на случай, если при пометке это не удалось
+		if task.Status == models.StatusExpired {
+			j.removeArchive(task)
+			err = j.db.DeleteTask(ctx, task.TaskId)
+			if err != nil {
+				j.logger.WithError(err).Errorf("delete task %s failed", task.TaskId)
+				continue
+			}
+			deleted++
+			continue
+		}
+
+		// статус задачи мог измениться после GetTasks, например Idle задача получила ссылки
+		err = j.db.ReplaceStatus(ctx, task.TaskId, task.Status, models.StatusExpired)
+		if err != nil {
+			j.logger.WithError(err).Errorf("expire task %s failed", task.TaskId)
+			continue
+		}
+		expired++
+		j.removeArchive(task)
+	}
+
+	if expired > 0 || deleted > 0 {
+		j.logger.Infof("janitor: %d tasks expired, %d tasks deleted", expired, deleted)
+	}
+	return nil
+}
+
+func (j *Janitor) removeArchive(task models.Task) {
+	if task.ZipPath == "" {
+		return
+	}
+	err := os.Remove(task.ZipPath)
+	if err != nil && !os.IsNotExist(err) {
+		j.logger.WithError(err).Errorf("remove archive %s failed", task.ZipPath)
+	}
+}
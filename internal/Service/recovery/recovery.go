@@ -0,0 +1,236 @@
+package recovery
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	d "github.com/JonnyShabli/23.07.2025/internal/Service/downloader"
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+	"github.com/JonnyShabli/23.07.2025/internal/repository"
+	"github.com/JonnyShabli/23.07.2025/pkg/logster"
+)
+
+const (
+	interruptedError = "task interrupted by service restart"
+	brokenZipError   = "archive is missing or corrupted"
+	authLostError    = "task credentials were not kept across service restart"
+)
+
+type RecoveryConfig struct {
+	// Requeue - заново отправлять ссылки прерванных задач в загрузчик,
+	// иначе такие задачи помечаются как Failed
+	Requeue bool `yaml:"requeue"`
+}
+
+// Recovery сверяет задачи в хранилище с архивами на диске при старте сервиса
+type Recovery struct {
+	requeue     bool
+	archivePath string
+	db          repository.StorageInterface
+	downloader  d.DownloaderInterface
+	logger      logster.Logger
+}
+
+func NewRecovery(cfg RecoveryConfig, archivePath string, db repository.StorageInterface, downloader d.DownloaderInterface, logger logster.Logger) *Recovery {
+	return &Recovery{
+		requeue:     cfg.Requeue,
+		archivePath: archivePath,
+		db:          db,
+		downloader:  downloader,
+		logger:      logger.WithField("Layer", "Recovery"),
+	}
+}
+
+// Run выполняет проход восстановления. Загрузчик к этому моменту должен быть запущен,
+// так как ссылки прерванных задач отправляются в него.
+func (r *Recovery) Run(ctx context.Context) error {
+	tasks, err := r.db.GetTasks(ctx)
+	if err != nil {
+		return fmt.Errorf("get tasks failed: %w", err)
+	}
+
+	known := make(map[string]struct{})
+	jobs := make([]models.DownloadJob, 0)
+	for _, task := range tasks {
+		archive := filepath.Join(r.archivePath, task.TaskId+models.ArchiveExt(task.ArchiveFormat))
+		known[filepath.Base(archive)] = struct{}{}
+
+		switch task.Status {
+		case models.StatusProcessing:
+			// архив прерванной задачи недописан, его собираем заново
+			err = removeIfExist(archive)
+			if err != nil {
+				return err
+			}
+			// без учетных данных ссылки не скачать, такую задачу не перезапускаем
+			if r.requeue && len(task.Links) > 0 && !task.AuthLost {
+				err = r.db.SetStatus(ctx, task.TaskId, models.StatusProcessing)
+				if err != nil {
+					return fmt.Errorf("reset task %s failed: %w", task.TaskId, err)
+				}
+				for _, link := range task.Links {
+					err = r.db.UpdateLink(ctx, task.TaskId, models.Link{
+						Index:          link.Index,
+						Url:            link.Url,
+						State:          models.LinkStatePending,
+						ExpectedDigest: link.ExpectedDigest,
+						ExpectedSize:   link.ExpectedSize,
+						Auth:           link.Auth,
+					})
+					if err != nil {
+						return fmt.Errorf("reset link %d of task %s failed: %w", link.Index, task.TaskId, err)
+					}
+					jobs = append(jobs, models.NewDownloadJob(task.TaskId, link))
+				}
+				r.logger.Infof("task %s requeued with %d links", task.TaskId, len(task.Links))
+				continue
+			}
+			reason := interruptedError
+			if task.AuthLost {
+				reason = authLostError
+			}
+			err = r.fail(ctx, task, models.ErrorClassInterrupted, reason)
+			if err != nil {
+				return err
+			}
+		case models.StatusDone, models.StatusPartiallyDone:
+			if validArchive(task.ZipPath, task.ArchiveFormat) {
+				continue
+			}
+			err = removeIfExist(archive)
+			if err != nil {
+				return err
+			}
+			err = r.fail(ctx, task, models.ErrorClassArchive, brokenZipError)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = r.removeOrphans(known)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for _, job := range jobs {
+			r.downloader.AddJob(job)
+		}
+	}()
+	r.logger.Infof("recovery finished, %d tasks checked, %d links requeued", len(tasks), len(jobs))
+	return nil
+}
+
+// fail переводит задачу в Failed, а ее ссылки, не завершившиеся ошибкой, - в Failed с причиной reason
+func (r *Recovery) fail(ctx context.Context, task models.Task, class, reason string) error {
+	now := time.Now()
+	for _, link := range task.Links {
+		if link.State == models.LinkStateFailed || link.State == models.LinkStateCancelled {
+			continue
+		}
+		link.State = models.LinkStateFailed
+		link.ErrorClass = class
+		link.Error = reason
+		link.FinishedAt = &now
+		err := r.db.UpdateLink(ctx, task.TaskId, link)
+		if err != nil {
+			return fmt.Errorf("fail link %d of task %s: %w", link.Index, task.TaskId, err)
+		}
+	}
+
+	err := r.db.SetStatus(ctx, task.TaskId, models.StatusFailed)
+	if err != nil {
+		return fmt.Errorf("mark task %s failed: %w", task.TaskId, err)
+	}
+	r.logger.Infof("task %s marked as %s: %s", task.TaskId, models.StatusFailed, reason)
+	return nil
+}
+
+// removeOrphans удаляет из директории архивов файлы, не принадлежащие ни одной задаче
+func (r *Recovery) removeOrphans(known map[string]struct{}) error {
+	entries, err := os.ReadDir(r.archivePath)
+	if err != nil {
+		return fmt.Errorf("read archive dir %s failed: %w", r.archivePath, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if _, ok := known[name]; ok {
+			continue
+		}
+		err = os.Remove(filepath.Join(r.archivePath, name))
+		if err != nil {
+			return fmt.Errorf("remove orphan file %s failed: %w", name, err)
+		}
+		r.logger.Infof("orphan file %s removed", name)
+	}
+	return nil
+}
+
+// validArchive проверяет, что архив дописан до конца и читается
+func validArchive(path, format string) bool {
+	if path == "" {
+		return false
+	}
+	if format == models.ArchiveFormatTarGz {
+		return validTarGz(path)
+	}
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return false
+	}
+	defer reader.Close()
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			return false
+		}
+		rc.Close()
+	}
+	return true
+}
+
+func validTarGz(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return false
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		_, err = tr.Next()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		_, err = io.Copy(io.Discard, tr)
+		if err != nil {
+			return false
+		}
+	}
+}
+
+func removeIfExist(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove archive %s failed: %w", path, err)
+	}
+	return nil
+}
@@ -2,38 +2,97 @@ package Service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
 
 	d "github.com/JonnyShabli/23.07.2025/internal/Service/downloader"
+	"github.com/JonnyShabli/23.07.2025/internal/Service/zipper"
 	"github.com/JonnyShabli/23.07.2025/internal/models"
 	"github.com/JonnyShabli/23.07.2025/internal/repository"
 	"github.com/JonnyShabli/23.07.2025/pkg/logster"
 )
 
+var ErrInvalidRequest = errors.New("invalid request")
+
 type ServiceInterface interface {
-	AddTask(ctx context.Context) (string, error)
-	AddLinks(ctx context.Context, links []string, id string) (int, error)
+	AddTask(ctx context.Context, clientId string) (string, error)
+	CreateTask(ctx context.Context, req models.CreateTaskRequest, clientId string) (*models.Status, error)
+	AddLinks(ctx context.Context, links []models.LinkRequest, id string) (int, error)
 	GetStatus(ctx context.Context, id string) (*models.Status, error)
+	CheckOwner(ctx context.Context, id, clientId string) error
+	ListTasks(ctx context.Context, filter models.TaskFilter) (*models.TaskList, error)
+	CancelTask(ctx context.Context, id string) error
+	DeleteTask(ctx context.Context, id string) error
+	BeginIdempotency(ctx context.Context, key, requestHash string) (*models.IdempotencyRecord, error)
+	SaveIdempotency(ctx context.Context, record models.IdempotencyRecord) error
+	DeleteIdempotency(ctx context.Context, key string) error
 }
 
 type ServiceObj struct {
 	db         repository.StorageInterface
 	logger     logster.Logger
 	Downloader d.DownloaderInterface
+	Zipper     zipper.ZipperInterface
 }
 
-func NewServiceObj(db repository.StorageInterface, logger logster.Logger, downloader d.DownloaderInterface) *ServiceObj {
+func NewServiceObj(db repository.StorageInterface, logger logster.Logger, downloader d.DownloaderInterface, zipperMgr zipper.ZipperInterface) *ServiceObj {
 	return &ServiceObj{
 		db:         db,
 		logger:     logger.WithField("Layer", "Service"),
 		Downloader: downloader,
+		Zipper:     zipperMgr,
 	}
 }
 
-func (s *ServiceObj) AddTask(ctx context.Context) (string, error) {
-	return s.db.AddTask(ctx)
+func (s *ServiceObj) AddTask(ctx context.Context, clientId string) (string, error) {
+	return s.db.AddTask(ctx, clientId)
 }
 
-func (s *ServiceObj) AddLinks(ctx context.Context, links []string, id string) (int, error) {
+// CreateTask создает задачу сразу со ссылками и параметрами архива
+func (s *ServiceObj) CreateTask(ctx context.Context, req models.CreateTaskRequest, clientId string) (*models.Status, error) {
+	format := req.ArchiveFormat
+	if format == "" {
+		format = models.ArchiveFormatZip
+	}
+	if format != models.ArchiveFormatZip && format != models.ArchiveFormatTarGz {
+		return nil, fmt.Errorf("%w: unsupported archive format %q", ErrInvalidRequest, format)
+	}
+	if req.CallbackUrl != "" {
+		u, err := url.Parse(req.CallbackUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%w: bad callback url %q", ErrInvalidRequest, req.CallbackUrl)
+		}
+		err = s.Downloader.CheckUrl(req.CallbackUrl)
+		if err != nil {
+			return nil, fmt.Errorf("%w: callback url: %w", ErrInvalidRequest, err)
+		}
+	}
+	err := req.Auth.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	task := models.Task{
+		Name:          req.Name,
+		Labels:        req.Labels,
+		ArchiveFormat: format,
+		CallbackUrl:   req.CallbackUrl,
+		ClientId:      clientId,
+		Auth:          req.Auth,
+	}
+	created, err := s.db.CreateTask(ctx, task, req.Links)
+	if err != nil {
+		s.logger.WithError(err).Errorf("CreateTask error")
+		return nil, err
+	}
+	s.enqueue(created.TaskId, created.Links)
+	s.logger.Infof("CreateTask: task %s created with %d links", created.TaskId, len(created.Links))
+	return models.NewStatus(created), nil
+}
+
+func (s *ServiceObj) AddLinks(ctx context.Context, links []models.LinkRequest, id string) (int, error) {
 	var result models.ValueAndError
 	ch := make(chan models.ValueAndError)
 	select {
@@ -44,14 +103,14 @@ func (s *ServiceObj) AddLinks(ctx context.Context, links []string, id string) (i
 	}
 
 	go func() {
-		linksCount, err := s.db.AddLinks(ctx, links, id)
+		added, err := s.db.AddLinks(ctx, links, id)
 		if err != nil {
 			s.logger.WithError(err).Errorf("AddLinks error")
 			result.Err = err
 			ch <- result
 			return
 		}
-		result.Value = linksCount
+		result.Value = added
 		ch <- result
 	}()
 
@@ -60,19 +119,29 @@ func (s *ServiceObj) AddLinks(ctx context.Context, links []string, id string) (i
 		s.logger.WithError(ctx.Err()).Errorf("AddLinks: context expire")
 		return 0, ctx.Err()
 	case v := <-ch:
-		s.logger.Infof("AddLinks: added %v links", v.Value)
-		go func() {
-			for _, url := range links {
-				job := models.DownloadJob{
-					TaskId: id,
-					Url:    url,
-				}
-				s.Downloader.AddJob(job)
-			}
-		}()
+		if v.Err != nil {
+			return 0, v.Err
+		}
+		added := v.Value.([]models.Link)
+		s.logger.Infof("AddLinks: added %v links", len(added))
+		s.enqueue(id, added)
+
+		return len(added), nil
+	}
+}
 
-		return v.Value.(int), v.Err
+// CheckOwner проверяет, что задачу создал клиент clientId. Чужая задача для клиента
+// не существует: ErrTaskNotFound, а не отказ в доступе, чтобы не раскрывать ее id
+func (s *ServiceObj) CheckOwner(ctx context.Context, id, clientId string) error {
+	task, err := s.db.GetTask(ctx, id)
+	if err != nil {
+		return err
 	}
+	if task.ClientId != clientId {
+		s.logger.Infof("CheckOwner: task %s belongs to another client", id)
+		return repository.ErrTaskNotFound
+	}
+	return nil
 }
 
 func (s *ServiceObj) GetStatus(ctx context.Context, id string) (*models.Status, error) {
@@ -95,6 +164,15 @@ func (s *ServiceObj) GetStatus(ctx context.Context, id string) (*models.Status,
 			return
 		}
 
+		if task.Status == models.StatusExpired {
+			s.logger.Infof("GetStatus: task %s expired", id)
+			ch <- models.ValueAndError{
+				Value: nil,
+				Err:   repository.ErrTaskExpired,
+			}
+			return
+		}
+
 		//if task == nil {
 		//	err = errors.New("task is nil")
 		//	s.logger.WithError(err).Errorf("GetTask error")
@@ -105,18 +183,8 @@ func (s *ServiceObj) GetStatus(ctx context.Context, id string) (*models.Status,
 		//	return
 		//}
 		s.logger.Infof("Get status succesfully with status: %s", task.Status)
-		result := &models.Status{
-			TaskId:        task.TaskId,
-			LinksStatuses: task.LinksStatuses,
-			LinksError:    task.LinksError,
-			Status:        task.Status,
-		}
-		if task.Status == models.StatusDone {
-
-			result.ZipPath = task.ZipPath
-		}
 		ch <- models.ValueAndError{
-			Value: result,
+			Value: models.NewStatus(task),
 			Err:   nil,
 		}
 	}()
@@ -132,3 +200,87 @@ func (s *ServiceObj) GetStatus(ctx context.Context, id string) (*models.Status,
 		return v.Value.(*models.Status), nil
 	}
 }
+
+func (s *ServiceObj) ListTasks(ctx context.Context, filter models.TaskFilter) (*models.TaskList, error) {
+	tasks, next, err := s.db.ListTasks(ctx, filter)
+	if err != nil {
+		s.logger.WithError(err).Errorf("ListTasks error")
+		return nil, err
+	}
+
+	result := &models.TaskList{
+		Tasks:      make([]*models.Status, 0, len(tasks)),
+		NextCursor: next,
+	}
+	for _, task := range tasks {
+		result.Tasks = append(result.Tasks, models.NewStatus(task))
+	}
+	return result, nil
+}
+
+// CancelTask останавливает загрузки задачи и выбрасывает ее недописанный архив
+func (s *ServiceObj) CancelTask(ctx context.Context, id string) error {
+	err := s.db.SetStatus(ctx, id, models.StatusCancelled)
+	if err != nil {
+		s.logger.WithError(err).Errorf("CancelTask: status not changed")
+		return err
+	}
+	s.stopTask(ctx, id)
+	s.logger.Infof("CancelTask: task %s cancelled", id)
+	return nil
+}
+
+// DeleteTask удаляет задачу вместе с архивом, активная задача предварительно останавливается
+func (s *ServiceObj) DeleteTask(ctx context.Context, id string) error {
+	task, err := s.db.GetTask(ctx, id)
+	if err != nil {
+		s.logger.WithError(err).Errorf("DeleteTask: GetTask error")
+		return err
+	}
+
+	err = s.db.DeleteTask(ctx, id)
+	if err != nil {
+		s.logger.WithError(err).Errorf("DeleteTask: task not deleted")
+		return err
+	}
+	if models.IsActive(task.Status) {
+		s.stopTask(ctx, id)
+	}
+	if task.ZipPath != "" {
+		err = os.Remove(task.ZipPath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove archive %s failed: %w", task.ZipPath, err)
+		}
+	}
+	s.logger.Infof("DeleteTask: task %s deleted", id)
+	return nil
+}
+
+// enqueue отправляет ссылки задачи в загрузчик, не блокируя вызывающего
+func (s *ServiceObj) enqueue(id string, links []models.Link) {
+	go func() {
+		for _, link := range links {
+			s.Downloader.AddJob(models.NewDownloadJob(id, link))
+		}
+	}()
+}
+
+func (s *ServiceObj) stopTask(ctx context.Context, id string) {
+	s.Downloader.CancelTask(id)
+	err := s.Zipper.Discard(ctx, id)
+	if err != nil {
+		s.logger.WithError(err).Errorf("discard archive of task %s failed", id)
+	}
+}
+
+func (s *ServiceObj) BeginIdempotency(ctx context.Context, key, requestHash string) (*models.IdempotencyRecord, error) {
+	return s.db.BeginIdempotency(ctx, key, requestHash)
+}
+
+func (s *ServiceObj) SaveIdempotency(ctx context.Context, record models.IdempotencyRecord) error {
+	return s.db.SaveIdempotency(ctx, record)
+}
+
+func (s *ServiceObj) DeleteIdempotency(ctx context.Context, key string) error {
+	return s.db.DeleteIdempotency(ctx, key)
+}
@@ -0,0 +1,69 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+// archiveWriter пишет файлы в архив одного из поддерживаемых форматов
+type archiveWriter interface {
+	Add(name string, r io.Reader, size int64) error
+	Close() error
+}
+
+func newArchiveWriter(format string, w io.Writer) archiveWriter {
+	if format == models.ArchiveFormatTarGz {
+		gz := gzip.NewWriter(w)
+		return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	return &zipWriter{zw: zip.NewWriter(w)}
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) Add(name string, r io.Reader, size int64) error {
+	w, err := z.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarGzWriter) Add(name string, r io.Reader, size int64) error {
+	err := t.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(t.tw, r, size)
+	return err
+}
+
+func (t *tarGzWriter) Close() error {
+	err := t.tw.Close()
+	if err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
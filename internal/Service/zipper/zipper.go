@@ -1,15 +1,16 @@
 package zipper
 
 import (
-	"archive/zip"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/JonnyShabli/23.07.2025/internal/models"
 	"github.com/JonnyShabli/23.07.2025/internal/repository"
@@ -17,7 +18,8 @@ import (
 )
 
 type ZipperInterface interface {
-	Start(ctx context.Context) error
+	Start(ctx context.Context, logger logster.Logger) error
+	Discard(ctx context.Context, taskId string) error
 }
 
 type ZipperConfig struct {
@@ -25,18 +27,40 @@ type ZipperConfig struct {
 	MaxFiles    int    `yaml:"max_files"`
 }
 
+const callbackTimeout = 10 * time.Second
+
+var ErrZipperStopped = errors.New("zipper is not running")
+
 type Zipper struct {
+	client   *http.Client
 	in       chan models.ZipJob
+	discard  chan string
+	stopped  chan struct{}
 	db       repository.StorageInterface
 	logger   logster.Logger
 	baseBath string
 	maxFiles int
 }
 
-func NewZipper(cfg ZipperConfig, logger logster.Logger, in chan models.ZipJob, db repository.StorageInterface) *Zipper {
+// taskArchive - состояние архива задачи, пока в него поступают файлы
+type taskArchive struct {
+	file   *os.File
+	writer archiveWriter
+	format string
+	path   string
+	files  int
+	names  map[string]int
+}
+
+// NewZipper создает менеджер архивов, client отправляет уведомления о завершении задач
+// и должен проверять адреса так же, как загрузчик
+func NewZipper(cfg ZipperConfig, logger logster.Logger, in chan models.ZipJob, db repository.StorageInterface, client *http.Client) *Zipper {
 	return &Zipper{
+		client:   client,
 		baseBath: cfg.ArchivePath,
 		in:       in,
+		discard:  make(chan string),
+		stopped:  make(chan struct{}),
 		db:       db,
 		logger:   logger,
 		maxFiles: cfg.MaxFiles,
@@ -44,126 +68,82 @@ func NewZipper(cfg ZipperConfig, logger logster.Logger, in chan models.ZipJob, d
 }
 
 func (z *Zipper) Start(ctx context.Context, logger logster.Logger) error {
-	doneCh := make(chan models.ValueAndError)
-	var result models.ValueAndError
-	wg := &sync.WaitGroup{}
+	// буфер, чтобы цикл мог завершиться, даже если Start уже вернулся по ctx
+	doneCh := make(chan models.ValueAndError, 1)
 
-	wg.Add(1)
 	go func() {
-		archives := make(map[string]*os.File)
-		zipWriters := make(map[string]*zip.Writer)
-		counterMap := make(map[string]int)
-		linksCodes := make(map[string]map[string]string)
-		linksErrors := make(map[string]map[string]string)
-		pathStorage := make(map[string]string)
-		filesInArchive := make(map[string]map[string]int)
-		var filename string
-
-		for job := range z.in {
-			z.logger.Infof("job recived from downloader")
-			// сохраняем ошибки для каждой ссылки
-			if job.Err != nil {
-				//TODO обработать тип ошибки
-				if linksErrors[job.TaskId] == nil {
-					linksErrors[job.TaskId] = make(map[string]string)
+		// после выхода из цикла архивы никто не отбрасывает, Discard не должен ждать
+		defer close(z.stopped)
+		archives := make(map[string]*taskArchive)
+
+		for {
+			var job models.ZipJob
+			select {
+			case taskId := <-z.discard:
+				z.discardArchive(archives, taskId)
+				continue
+			case v, ok := <-z.in:
+				if !ok {
+					doneCh <- models.ValueAndError{}
+					return
 				}
-				linksErrors[job.TaskId][job.Url] = job.Err.Error()
-				doneCh <- models.ValueAndError{}
-				return
+				job = v
 			}
+			z.logger.Infof("job recived from downloader")
 
-			filename = job.FileName
-
-			// сохраняем код ответа для каждой ссылки
-			if linksCodes[job.TaskId] == nil {
-				linksCodes[job.TaskId] = make(map[string]string)
+			task, err := z.db.GetTask(ctx, job.TaskId)
+			if err != nil || !models.IsActive(task.Status) {
+				// задача удалена, отменена или просрочена, пока файл скачивался
+				z.logger.Infof("task %s is not active, job for %s dropped", job.TaskId, models.RedactedUrl(job.Url))
+				removeSpool(job.SpoolPath)
+				z.discardArchive(archives, job.TaskId)
+				continue
 			}
-			linksCodes[job.TaskId][job.Url] = job.ResponseStatus
 
-			// создаем файл архива, если он не существует и сохраняем соответствующий ему
-			// zipWriter в zipWriters
-
-			archivePath := filepath.Join(z.baseBath, job.TaskId+".zip")
-			_, ok := archives[job.TaskId]
+			archive, ok := archives[job.TaskId]
 			if !ok {
-				file, err := os.Create(archivePath)
-				if err != nil {
-					result.Err = fmt.Errorf("create zip file %s failed: %w", archivePath, err)
-					doneCh <- result
-					return
+				archive = &taskArchive{
+					path:   filepath.Join(z.baseBath, job.TaskId+models.ArchiveExt(task.ArchiveFormat)),
+					format: task.ArchiveFormat,
+					names:  make(map[string]int),
 				}
-				archives[job.TaskId] = file
-				zipWriters[job.TaskId] = zip.NewWriter(file)
-				pathStorage[job.TaskId] = archivePath
+				archives[job.TaskId] = archive
 			}
 
-			if filesInArchive[job.TaskId] == nil {
-				filesInArchive[job.TaskId] = make(map[string]int)
-			}
-			n, ok := filesInArchive[job.TaskId][job.FileName]
-			filesInArchive[job.TaskId][filename]++
-			if ok {
-				ext := filepath.Ext(job.FileName)
-				base := strings.TrimSuffix(job.FileName, ext)
-				filename = fmt.Sprintf("%s_%d.%s", base, n, ext)
+			// ошибка одной ссылки не прерывает обработку задачи, а сохраняется в записи о ссылке
+			link := linkFromJob(task, job)
+			name, err := z.addFile(archive, job)
+			if err != nil {
+				link.State = models.LinkStateFailed
+				link.Error = err.Error()
+				if link.ErrorClass == "" {
+					link.ErrorClass = models.ErrorClassArchive
+				}
+				if link.ErrorClass == models.ErrorClassCancelled {
+					link.State = models.LinkStateCancelled
+				}
+			} else {
+				link.State = models.LinkStateDone
+				link.FileName = name
 			}
-
-			// создаем файл в соответствующем taskId архиве
-			zipFileWriter, err := zipWriters[job.TaskId].Create(filename)
+			err = z.db.UpdateLink(ctx, job.TaskId, link)
 			if err != nil {
-				result.Err = fmt.Errorf("create zipFileWriter %s failed: %w", filename, err)
-				doneCh <- result
-				return
+				z.logger.WithError(err).Errorf("update link %d of task %s failed", job.Index, job.TaskId)
+				z.discardArchive(archives, job.TaskId)
+				continue
 			}
 
-			// записываем файл в архив
-			buf := bytes.NewBuffer(*job.Data)
-			_, err = io.Copy(zipFileWriter, buf)
+			// все ссылки задачи обработаны, закрываем архив и обновляем задачу в хранилище
+			task, err = z.db.GetTask(ctx, job.TaskId)
 			if err != nil {
-				result.Err = fmt.Errorf("copy zipFileWriter %s failed: %w", filename, err)
-				doneCh <- result
-				return
+				z.discardArchive(archives, job.TaskId)
+				continue
 			}
-
-			// считаем кол-во запакованных файлов для каждой таски
-			counterMap[job.TaskId]++
-
-			// если скачано 3 файла, то сбрасываем счетчик и обновляем Task в хранилище
-			if counterMap[job.TaskId] == z.maxFiles {
-				delete(counterMap, job.TaskId)
-
-				// закрываем zipWriter
-				err = zipWriters[job.TaskId].Close()
-				if err != nil {
-					result.Err = fmt.Errorf("close zipWriter %s failed: %w", filename, err)
-					doneCh <- result
-					return
-				}
-				// удаляем zipWriter из мапы
-				delete(zipWriters, job.TaskId)
-
-				// закрываем файл
-				err = archives[job.TaskId].Close()
-				if err != nil {
-					result.Err = fmt.Errorf("close archive file %s.zip failed: %w", archives[job.TaskId].Name(), err)
-					doneCh <- result
-					return
-				}
-				// удаляем zip файл из мапы
+			if allFinished(task.Links) {
 				delete(archives, job.TaskId)
-
-				data := models.Task{
-					LinksStatuses: linksCodes[job.TaskId],
-					LinksError:    linksErrors[job.TaskId],
-					ZipPath:       pathStorage[job.TaskId],
-					Status:        models.StatusDone,
-				}
-
-				// обновляем запись в хранилище
-				err = z.db.AddZip(ctx, data, job.TaskId)
+				err = z.finish(ctx, task, archive)
 				if err != nil {
-					result.Err = fmt.Errorf("add zip file %s to db failed: %w", filename, err)
-					doneCh <- result
+					doneCh <- models.ValueAndError{Err: err}
 					return
 				}
 			}
@@ -181,7 +161,226 @@ func (z *Zipper) Start(ctx context.Context, logger logster.Logger) error {
 	}
 }
 
-func exist(path string) bool {
-	_, err := os.Stat(path)
-	return !os.IsNotExist(err)
+// addFile записывает скачанный файл в архив задачи, создавая архив при первом файле,
+// и возвращает имя файла в архиве
+func (z *Zipper) addFile(archive *taskArchive, job models.ZipJob) (string, error) {
+	// временный файл загрузки больше не нужен, что бы ни случилось с архивом
+	defer removeSpool(job.SpoolPath)
+	if job.Err != nil {
+		return "", job.Err
+	}
+	if job.SpoolPath == "" {
+		return "", errors.New("downloaded file is missing")
+	}
+
+	if z.maxFiles > 0 && archive.files >= z.maxFiles {
+		return "", fmt.Errorf("archive file limit %d exceeded", z.maxFiles)
+	}
+
+	// создаем файл архива, если он не существует
+	if archive.writer == nil {
+		file, err := os.Create(archive.path)
+		if err != nil {
+			return "", fmt.Errorf("create zip file %s failed: %w", archive.path, err)
+		}
+		archive.file = file
+		archive.writer = newArchiveWriter(archive.format, file)
+	}
+
+	filename := job.FileName
+	n, ok := archive.names[job.FileName]
+	archive.names[job.FileName]++
+	if ok {
+		ext := filepath.Ext(job.FileName)
+		base := strings.TrimSuffix(job.FileName, ext)
+		filename = fmt.Sprintf("%s_%d%s", base, n, ext)
+	}
+
+	// записываем файл в архив
+	spool, err := os.Open(job.SpoolPath)
+	if err != nil {
+		return "", fmt.Errorf("open downloaded file failed: %w", err)
+	}
+	defer spool.Close()
+	err = archive.writer.Add(filename, spool, job.Size)
+	if err != nil {
+		return "", fmt.Errorf("add file %s to archive failed: %w", filename, err)
+	}
+	archive.files++
+	return filename, nil
+}
+
+// finish закрывает архив и переводит задачу в итоговый статус:
+// Done - все файлы в архиве, PartiallyDone - часть ссылок с ошибками, Failed - архив пуст
+func (z *Zipper) finish(ctx context.Context, task models.Task, archive *taskArchive) error {
+	var data models.Task
+	var failed int
+	for _, link := range task.Links {
+		if link.State != models.LinkStateDone {
+			failed++
+		}
+	}
+
+	if archive.writer != nil {
+		err := archive.writer.Close()
+		if err != nil {
+			archive.file.Close()
+			return fmt.Errorf("close archive writer %s failed: %w", archive.path, err)
+		}
+		err = archive.file.Close()
+		if err != nil {
+			return fmt.Errorf("close archive file %s failed: %w", archive.path, err)
+		}
+	}
+
+	switch {
+	case archive.files == 0:
+		data.Status = models.StatusFailed
+		// архив мог быть создан первым файлом, который затем не удалось записать
+		err := removeIfExist(archive.path)
+		if err != nil {
+			z.logger.WithError(err).Errorf("remove empty archive of task %s failed", task.TaskId)
+		}
+	case failed > 0:
+		data.Status = models.StatusPartiallyDone
+		data.ZipPath = archive.path
+	default:
+		data.Status = models.StatusDone
+		data.ZipPath = archive.path
+	}
+
+	// обновляем запись в хранилище
+	err := z.db.AddZip(ctx, data, task.TaskId)
+	if errors.Is(err, repository.ErrIllegalTransition) || errors.Is(err, repository.ErrTaskNotFound) {
+		// задачу успели отменить или удалить, архив больше не нужен
+		z.logger.WithError(err).Infof("task %s finished but not updated", task.TaskId)
+		return removeIfExist(archive.path)
+	}
+	if err != nil {
+		return fmt.Errorf("add zip file %s to db failed: %w", archive.path, err)
+	}
+	z.logger.Infof("task %s finished with status %s", task.TaskId, data.Status)
+
+	if task.CallbackUrl != "" {
+		finished, err := z.db.GetTask(ctx, task.TaskId)
+		if err != nil {
+			z.logger.WithError(err).Errorf("get finished task %s failed", task.TaskId)
+			return nil
+		}
+		go z.callback(finished)
+	}
+	return nil
+}
+
+// callback уведомляет клиента о завершении задачи, отправляя ее статус на CallbackUrl
+func (z *Zipper) callback(task models.Task) {
+	status := models.NewStatus(task)
+	// путь к архиву на диске клиенту не нужен, ссылку на скачивание отдает GetStatus
+	status.ZipPath = ""
+	body, err := json.Marshal(status)
+	if err != nil {
+		z.logger.WithError(err).Errorf("encode callback for task %s failed", task.TaskId)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), callbackTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, task.CallbackUrl, bytes.NewReader(body))
+	if err != nil {
+		z.logger.WithError(err).Errorf("create callback request for task %s failed", task.TaskId)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	resp, err := z.client.Do(req)
+	if err != nil {
+		z.logger.WithError(err).Errorf("callback for task %s failed", task.TaskId)
+		return
+	}
+	defer resp.Body.Close()
+	z.logger.Infof("callback for task %s sent, response status %s", task.TaskId, resp.Status)
+}
+
+// linkFromJob собирает запись о ссылке из результата скачивания,
+// переданные клиентом параметры ссылки берутся из задачи
+func linkFromJob(task models.Task, job models.ZipJob) models.Link {
+	link := models.Link{
+		Index:       job.Index,
+		Url:         job.Url,
+		FinalUrl:    job.FinalUrl,
+		Redirects:   job.Redirects,
+		HttpCode:    job.HttpCode,
+		ContentType: job.ContentType,
+		Size:        job.Size,
+		Sha256:      job.Sha256,
+		Digest:      job.Digest,
+		Attempts:    job.Attempts,
+		ErrorClass:  job.ErrorClass,
+		Transient:   job.Transient,
+	}
+	if job.Index >= 0 && job.Index < len(task.Links) {
+		link.ExpectedDigest = task.Links[job.Index].ExpectedDigest
+		link.ExpectedSize = task.Links[job.Index].ExpectedSize
+		link.Auth = task.Links[job.Index].Auth
+	}
+	if job.DeclaredType != job.ContentType {
+		link.DeclaredType = job.DeclaredType
+	}
+	if !job.StartedAt.IsZero() {
+		link.StartedAt = &job.StartedAt
+	}
+	if !job.FinishedAt.IsZero() {
+		link.FinishedAt = &job.FinishedAt
+	}
+	return link
+}
+
+func allFinished(links []models.Link) bool {
+	for _, link := range links {
+		if !link.Finished() {
+			return false
+		}
+	}
+	return true
+}
+
+// Discard просит менеджер архивов выбросить недописанный архив задачи
+func (z *Zipper) Discard(ctx context.Context, taskId string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-z.stopped:
+		return ErrZipperStopped
+	case z.discard <- taskId:
+		return nil
+	}
+}
+
+// discardArchive закрывает и удаляет недописанный архив задачи
+func (z *Zipper) discardArchive(archives map[string]*taskArchive, taskId string) {
+	archive, ok := archives[taskId]
+	if !ok {
+		return
+	}
+	delete(archives, taskId)
+	if archive.file != nil {
+		archive.file.Close()
+	}
+	err := removeIfExist(archive.path)
+	if err != nil {
+		z.logger.WithError(err).Errorf("discard archive failed")
+	}
+}
+
+func removeSpool(path string) {
+	if path != "" {
+		os.Remove(path)
+	}
+}
+
+func removeIfExist(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove archive %s failed: %w", path, err)
+	}
+	return nil
 }
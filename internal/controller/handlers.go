@@ -1,56 +1,117 @@
 package controller
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/JonnyShabli/23.07.2025/internal/Service"
 	"github.com/JonnyShabli/23.07.2025/internal/models"
+	"github.com/JonnyShabli/23.07.2025/internal/repository"
 	"github.com/JonnyShabli/23.07.2025/pkg/logster"
 	"github.com/go-chi/chi/v5"
 )
 
-const archivesDir = "./tmp/archives/"
+const (
+	archivesDir  = "./tmp/archives/"
+	apiKeyHeader = "X-API-Key"
+)
 
 type HandlerInterface interface {
 	AddTask(w http.ResponseWriter, r *http.Request)
 	AddLinks(w http.ResponseWriter, r *http.Request)
+	CreateTask(w http.ResponseWriter, r *http.Request)
 	GetStatus(w http.ResponseWriter, r *http.Request)
 	DownloadZip(w http.ResponseWriter, r *http.Request)
+	ListTasks(w http.ResponseWriter, r *http.Request)
+	CancelTask(w http.ResponseWriter, r *http.Request)
+	DeleteTask(w http.ResponseWriter, r *http.Request)
+}
+
+// ApiConfig - настройки API. ApiKeys - ключи, выданные клиентам: клиент с известным ключом
+// определяется по ключу, с неизвестным или без ключа - по IP адресу
+type ApiConfig struct {
+	ApiKeys []models.Secret `yaml:"api_keys"`
 }
 
 type HandlerObj struct {
 	Service  Service.ServiceInterface
 	Logger   logster.Logger
 	hostname string
+	apiKeys  map[string]struct{}
 }
 
-func NewHandlers(service Service.ServiceInterface, logger logster.Logger, hostName string) *HandlerObj {
+func NewHandlers(cfg ApiConfig, service Service.ServiceInterface, logger logster.Logger, hostName string) *HandlerObj {
+	apiKeys := make(map[string]struct{}, len(cfg.ApiKeys))
+	for _, key := range cfg.ApiKeys {
+		apiKeys[keyId(string(key))] = struct{}{}
+	}
 	return &HandlerObj{
 		Service:  service,
 		Logger:   logger.WithField("Layer", "Handlers"),
 		hostname: hostName,
+		apiKeys:  apiKeys,
 	}
 }
 
 func (h *HandlerObj) AddTask(w http.ResponseWriter, r *http.Request) {
+	h.withIdempotency(w, r, h.addTask)
+}
+
+func (h *HandlerObj) addTask(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id, err := h.Service.AddTask(ctx)
+	id, err := h.Service.AddTask(ctx, h.clientId(r))
 	if err != nil {
 		h.Logger.WithError(err).Errorf("Add task failed")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		ErrorStatusResponse(w, err)
 		return
 	}
 	h.Logger.Infof("Add task successfully with Id: %s", id)
 	SuccessDataResponse(w, h.Logger, "Success", id)
 }
 
+func (h *HandlerObj) CreateTask(w http.ResponseWriter, r *http.Request) {
+	h.withIdempotency(w, r, h.createTask)
+}
+
+func (h *HandlerObj) createTask(w http.ResponseWriter, r *http.Request) {
+	createReq := models.CreateTaskRequest{}
+	ctx := r.Context()
+	err := json.NewDecoder(r.Body).Decode(&createReq)
+	if err != nil {
+		err = fmt.Errorf("fail to unmarshal request body '%w'", err)
+		h.Logger.WithError(err).Infof("fail to unmarshal request body")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status, err := h.Service.CreateTask(ctx, createReq, h.clientId(r))
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Create task failed")
+		ErrorStatusResponse(w, err)
+		return
+	}
+	h.Logger.Infof("Create task successfully with Id: %s", status.TaskId)
+	SuccessDataResponse(w, h.Logger, "Success", status)
+}
+
 func (h *HandlerObj) AddLinks(w http.ResponseWriter, r *http.Request) {
+	h.withIdempotency(w, r, h.addLinks)
+}
+
+func (h *HandlerObj) addLinks(w http.ResponseWriter, r *http.Request) {
 	linksReq := models.AddLinksRequest{}
 	ctx := r.Context()
 	reqBody, err := io.ReadAll(r.Body)
@@ -66,11 +127,14 @@ func (h *HandlerObj) AddLinks(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !h.ownTask(w, r, linksReq.TaskId) {
+		return
+	}
 
 	n, err := h.Service.AddLinks(ctx, linksReq.Links, linksReq.TaskId)
 	if err != nil {
 		h.Logger.WithError(err).Infof("fail to add links")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		ErrorStatusResponse(w, err)
 		return
 	}
 	h.Logger.Infof("Add links successfully")
@@ -85,19 +149,79 @@ func (h *HandlerObj) GetStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "fail to get task_id", http.StatusBadRequest)
 		return
 	}
+	if !h.ownTask(w, r, taskId) {
+		return
+	}
 
 	status, err := h.Service.GetStatus(ctx, taskId)
 	if err != nil {
 		h.Logger.WithError(err).Errorf("fail to get task status")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		ErrorStatusResponse(w, err)
 		return
 	}
-	fullUrl := fmt.Sprintf("http://%s/download/%s", h.hostname, status.ZipPath)
-	status.ZipPath = fullUrl
+	status.ZipPath = h.downloadUrl(status.ZipPath)
 	h.Logger.Infof("Get task status successfully")
 	SuccessDataResponse(w, h.Logger, "Success", status)
 }
 
+func (h *HandlerObj) ListTasks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	filter, err := parseTaskFilter(r.URL.Query())
+	if err != nil {
+		h.Logger.WithError(err).Infof("fail to parse list filter")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// клиент видит только свои задачи
+	filter.ClientId = h.clientId(r)
+
+	list, err := h.Service.ListTasks(ctx, filter)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("fail to list tasks")
+		ErrorStatusResponse(w, err)
+		return
+	}
+	for _, status := range list.Tasks {
+		status.ZipPath = h.downloadUrl(status.ZipPath)
+	}
+	h.Logger.Infof("List tasks successfully")
+	SuccessDataResponse(w, h.Logger, "Success", list)
+}
+
+func (h *HandlerObj) CancelTask(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskId := chi.URLParam(r, "task_id")
+	if !h.ownTask(w, r, taskId) {
+		return
+	}
+
+	err := h.Service.CancelTask(ctx, taskId)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("fail to cancel task")
+		ErrorStatusResponse(w, err)
+		return
+	}
+	h.Logger.Infof("Cancel task successfully")
+	SuccessDataResponse(w, h.Logger, "Success", taskId)
+}
+
+func (h *HandlerObj) DeleteTask(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	taskId := chi.URLParam(r, "task_id")
+	if !h.ownTask(w, r, taskId) {
+		return
+	}
+
+	err := h.Service.DeleteTask(ctx, taskId)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("fail to delete task")
+		ErrorStatusResponse(w, err)
+		return
+	}
+	h.Logger.Infof("Delete task successfully")
+	SuccessDataResponse(w, h.Logger, "Success", taskId)
+}
+
 func (h *HandlerObj) DownloadZip(w http.ResponseWriter, r *http.Request) {
 	fileName := path.Base(r.URL.Path)
 	if fileName == "" {
@@ -105,17 +229,122 @@ func (h *HandlerObj) DownloadZip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// архив отдается только клиенту, создавшему задачу
+	taskId := strings.TrimSuffix(strings.TrimSuffix(fileName, models.ArchiveExt(models.ArchiveFormatZip)), models.ArchiveExt(models.ArchiveFormatTarGz))
+	err := h.Service.CheckOwner(r.Context(), taskId, h.clientId(r))
+	if err != nil {
+		http.Error(w, "archive not found", http.StatusNotFound)
+		return
+	}
+
 	// Проверяем существование файла
 	filePath := filepath.Join(archivesDir, fileName)
 
-	if info, err := os.Stat(filePath); os.IsNotExist(err) {
-		fmt.Println(info)
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		// архив мог быть удален вместе с просроченной задачей
+		_, err = h.Service.GetStatus(r.Context(), taskId)
+		if errors.Is(err, repository.ErrTaskExpired) {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
 		http.Error(w, "archive not found", http.StatusNotFound)
 		return
 	}
 
 	// Отдаём файл
-	w.Header().Set("Content-Type", "application/zip")
+	contentType := "application/zip"
+	if strings.HasSuffix(fileName, models.ArchiveExt(models.ArchiveFormatTarGz)) {
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
 	http.ServeFile(w, r, archivesDir+fileName)
 }
+
+// ownTask проверяет, что задача принадлежит клиенту запроса, иначе отвечает 404
+func (h *HandlerObj) ownTask(w http.ResponseWriter, r *http.Request, taskId string) bool {
+	err := h.Service.CheckOwner(r.Context(), taskId, h.clientId(r))
+	if err != nil {
+		h.Logger.WithError(err).Infof("task %s is not available to the client", taskId)
+		ErrorStatusResponse(w, err)
+		return false
+	}
+	return true
+}
+
+// clientId определяет клиента по API ключу из настроек, иначе - по IP адресу:
+// придуманный ключ не дает клиенту новую квоту
+func (h *HandlerObj) clientId(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		id := keyId(key)
+		if _, ok := h.apiKeys[id]; ok {
+			return id
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// keyId - идентификатор клиента по ключу, сам ключ не сохраняем, только его хэш
+func keyId(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:8])
+}
+
+func (h *HandlerObj) downloadUrl(zipPath string) string {
+	if zipPath == "" {
+		return ""
+	}
+	return fmt.Sprintf("http://%s/download/%s", h.hostname, zipPath)
+}
+
+// parseTaskFilter разбирает параметры запроса списка задач:
+// status (через запятую или повторяясь), created_from и created_to в RFC3339,
+// label в виде key:value (повторяясь), cursor и limit
+func parseTaskFilter(query url.Values) (models.TaskFilter, error) {
+	var filter models.TaskFilter
+	for _, v := range query["status"] {
+		for _, status := range strings.Split(v, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				filter.Statuses = append(filter.Statuses, status)
+			}
+		}
+	}
+
+	var err error
+	if v := query.Get("created_from"); v != "" {
+		filter.CreatedFrom, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("bad created_from: %w", err)
+		}
+	}
+	if v := query.Get("created_to"); v != "" {
+		filter.CreatedTo, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("bad created_to: %w", err)
+		}
+	}
+
+	for _, v := range query["label"] {
+		key, value, ok := strings.Cut(v, ":")
+		if !ok || key == "" {
+			return filter, fmt.Errorf("bad label %q, expected key:value", v)
+		}
+		if filter.Labels == nil {
+			filter.Labels = make(map[string]string)
+		}
+		filter.Labels[key] = value
+	}
+
+	if v := query.Get("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit <= 0 {
+			return filter, fmt.Errorf("bad limit %q", v)
+		}
+	}
+	filter.Cursor = query.Get("cursor")
+	return filter, nil
+}
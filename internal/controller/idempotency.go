@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+// responseRecorder запоминает ответ хэндлера, одновременно отдавая его клиенту
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// withIdempotency выполняет хэндлер не более одного раза для каждого Idempotency-Key клиента:
+// повторный запрос с тем же ключом и телом получает сохраненный ответ,
+// с тем же ключом и другим телом - 409 Conflict
+func (h *HandlerObj) withIdempotency(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		next(w, r)
+		return
+	}
+	ctx := r.Context()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.Logger.WithError(err).Infof("fail read request body")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	// ключ действует в рамках клиента и эндпоинта
+	scopedKey := h.clientId(r) + " " + r.Method + " " + r.URL.Path + " " + key
+	sum := sha256.Sum256(body)
+	requestHash := hex.EncodeToString(sum[:])
+
+	record, err := h.Service.BeginIdempotency(ctx, scopedKey, requestHash)
+	if err != nil {
+		h.Logger.WithError(err).Infof("idempotency key rejected")
+		ErrorStatusResponse(w, err)
+		return
+	}
+	if record != nil {
+		h.Logger.Infof("replay response for idempotency key %s", key)
+		if record.ContentType != "" {
+			w.Header().Set("Content-Type", record.ContentType)
+		}
+		w.Header().Set(idempotencyReplayedHeader, "true")
+		w.WriteHeader(record.StatusCode)
+		_, _ = w.Write(record.Body)
+		return
+	}
+
+	rec := &responseRecorder{ResponseWriter: w}
+	next(rec, r)
+
+	// временные ошибки не запоминаем, чтобы клиент мог повторить запрос с тем же ключом
+	if rec.status == 0 || rec.status == http.StatusTooManyRequests || rec.status >= http.StatusInternalServerError {
+		err = h.Service.DeleteIdempotency(ctx, scopedKey)
+		if err != nil {
+			h.Logger.WithError(err).Errorf("fail to release idempotency key")
+		}
+		return
+	}
+	err = h.Service.SaveIdempotency(ctx, models.IdempotencyRecord{
+		Key:         scopedKey,
+		RequestHash: requestHash,
+		StatusCode:  rec.status,
+		ContentType: rec.Header().Get("Content-Type"),
+		Body:        rec.body.Bytes(),
+	})
+	if err != nil {
+		h.Logger.WithError(err).Errorf("fail to save idempotency record")
+	}
+}
@@ -2,8 +2,12 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
+	"github.com/JonnyShabli/23.07.2025/internal/repository"
 	"github.com/JonnyShabli/23.07.2025/pkg/logster"
 )
 
@@ -70,3 +74,33 @@ func ErrorResponse(w http.ResponseWriter, log logster.Logger, errMsg string, dat
 	}
 
 }
+
+// errorStatusCode подбирает HTTP код ответа для ошибки сервисного слоя
+func errorStatusCode(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrTaskNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrTaskExpired):
+		return http.StatusGone
+	case errors.Is(err, repository.ErrInvalidCursor), errors.Is(err, repository.ErrNoLinks):
+		return http.StatusBadRequest
+	case errors.Is(err, repository.ErrIllegalTransition),
+		errors.Is(err, repository.ErrIdempotencyConflict),
+		errors.Is(err, repository.ErrIdempotencyInProgress):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrTooManyTasks):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// ErrorStatusResponse отвечает текстом ошибки с подходящим ей кодом,
+// для превышения лимита задач добавляет заголовок Retry-After
+func ErrorStatusResponse(w http.ResponseWriter, err error) {
+	if limitErr, ok := repository.AsLimitError(err); ok && limitErr.RetryAfter > 0 {
+		seconds := int(math.Ceil(limitErr.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	http.Error(w, err.Error(), errorStatusCode(err))
+}
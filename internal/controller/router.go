@@ -11,7 +11,10 @@ func WithApiHandler(api HandlerInterface) pkghttp.RouterOption {
 			r.Get("/", api.AddTask)
 			r.Post("/", api.AddLinks)
 			r.Get("/status/{task_id}", api.GetStatus)
-
+			r.Get("/tasks", api.ListTasks)
+			r.Post("/tasks", api.CreateTask)
+			r.Post("/{task_id}/cancel", api.CancelTask)
+			r.Delete("/{task_id}", api.DeleteTask)
 		})
 		r.Route("/", func(r chi.Router) {
 			r.Get("/download/*", api.DownloadZip)
@@ -0,0 +1,306 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// redacted - чем заменяются секреты в логах и ответах
+const redacted = "***"
+
+var ErrBadAuth = errors.New("bad auth")
+
+// reservedHeaders - заголовки, которые выставляет сам загрузчик или транспорт
+var reservedHeaders = map[string]struct{}{
+	"Host": {}, "Content-Length": {}, "Transfer-Encoding": {}, "Connection": {}, "Upgrade": {},
+	"Te": {}, "Trailer": {}, "Keep-Alive": {}, "Proxy-Connection": {}, "Proxy-Authorization": {},
+	"Range": {}, "If-Range": {}, "Cookie": {},
+}
+
+// Secret - значение, которое не должно попасть в логи: fmt печатает его как ***.
+// В JSON хранится как есть, в ответы клиенту попадает только после Auth.Redacted.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password Secret `json:"password,omitempty"`
+}
+
+// Auth - учетные данные для скачивания ссылок: произвольные заголовки, bearer токен,
+// basic авторизация и cookies. Отправляются только на хост ссылки, при редиректе
+// на другой хост не передаются.
+type Auth struct {
+	Headers map[string]Secret `json:"headers,omitempty"`
+	Bearer  Secret            `json:"bearer,omitempty"`
+	Basic   *BasicAuth        `json:"basic,omitempty"`
+	Cookies map[string]Secret `json:"cookies,omitempty"`
+}
+
+// Validate проверяет, что данные можно отправить в заголовках запроса
+func (a *Auth) Validate() error {
+	if a == nil {
+		return nil
+	}
+	credentials := 0
+	for name, value := range a.Headers {
+		if !isToken(name) {
+			return fmt.Errorf("%w: bad header name %q", ErrBadAuth, name)
+		}
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		if _, ok := reservedHeaders[key]; ok {
+			return fmt.Errorf("%w: header %s can not be set", ErrBadAuth, key)
+		}
+		if key == "Authorization" {
+			credentials++
+		}
+		if !isHeaderValue(string(value)) {
+			return fmt.Errorf("%w: bad value of header %s", ErrBadAuth, key)
+		}
+	}
+	if a.Bearer != "" {
+		credentials++
+		if !isToken68(string(a.Bearer)) {
+			return fmt.Errorf("%w: bad bearer token", ErrBadAuth)
+		}
+	}
+	if a.Basic != nil {
+		credentials++
+		if a.Basic.Username == "" || strings.Contains(a.Basic.Username, ":") || !isHeaderValue(a.Basic.Username) {
+			return fmt.Errorf("%w: bad basic auth username", ErrBadAuth)
+		}
+		if !isHeaderValue(string(a.Basic.Password)) {
+			return fmt.Errorf("%w: bad basic auth password", ErrBadAuth)
+		}
+	}
+	if credentials > 1 {
+		return fmt.Errorf("%w: only one of bearer, basic and Authorization header can be set", ErrBadAuth)
+	}
+	for name, value := range a.Cookies {
+		if !isToken(name) {
+			return fmt.Errorf("%w: bad cookie name %q", ErrBadAuth, name)
+		}
+		if !isHeaderValue(string(value)) || strings.ContainsAny(string(value), ";,\" ") {
+			return fmt.Errorf("%w: bad value of cookie %s", ErrBadAuth, name)
+		}
+	}
+	return nil
+}
+
+// hasCredentials - задан заголовок Authorization в каком-либо виде
+func (a *Auth) hasCredentials() bool {
+	if a.Bearer != "" || a.Basic != nil {
+		return true
+	}
+	for name := range a.Headers {
+		if textproto.CanonicalMIMEHeaderKey(name) == "Authorization" {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge накладывает данные ссылки на данные задачи: заголовки и cookies ссылки
+// заменяют одноименные, а ее bearer или basic заменяют авторизацию задачи целиком
+func (a *Auth) Merge(link *Auth) *Auth {
+	if a == nil && link == nil {
+		return nil
+	}
+	result := &Auth{}
+	for _, src := range []*Auth{a, link} {
+		if src == nil {
+			continue
+		}
+		if src.hasCredentials() {
+			result.Bearer, result.Basic = "", nil
+			delete(result.Headers, "Authorization")
+		}
+		for name, value := range src.Headers {
+			if result.Headers == nil {
+				result.Headers = make(map[string]Secret)
+			}
+			result.Headers[textproto.CanonicalMIMEHeaderKey(name)] = value
+		}
+		if src.Bearer != "" {
+			result.Bearer = src.Bearer
+		}
+		if src.Basic != nil {
+			basic := *src.Basic
+			result.Basic = &basic
+		}
+		for name, value := range src.Cookies {
+			if result.Cookies == nil {
+				result.Cookies = make(map[string]Secret)
+			}
+			result.Cookies[name] = value
+		}
+	}
+	return result
+}
+
+// RedactedUrl - адрес без пароля пользователя для логов и ответов клиенту.
+// Адрес, который не удалось разобрать, скрывается целиком.
+func RedactedUrl(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	return u.Redacted()
+}
+
+// Redacted - копия для ответов клиенту: имена заголовков, cookies и пользователя остаются, значения скрыты
+func (a *Auth) Redacted() *Auth {
+	if a == nil {
+		return nil
+	}
+	result := &Auth{Bearer: Secret(a.Bearer.String())}
+	if a.Headers != nil {
+		result.Headers = make(map[string]Secret, len(a.Headers))
+		for name, value := range a.Headers {
+			result.Headers[name] = Secret(value.String())
+		}
+	}
+	if a.Basic != nil {
+		result.Basic = &BasicAuth{Username: a.Basic.Username, Password: Secret(a.Basic.Password.String())}
+	}
+	if a.Cookies != nil {
+		result.Cookies = make(map[string]Secret, len(a.Cookies))
+		for name, value := range a.Cookies {
+			result.Cookies[name] = Secret(value.String())
+		}
+	}
+	return result
+}
+
+// MapSecrets - копия, в которой каждое непустое значение заменено результатом f,
+// например, зашифровано перед записью на диск. Возвращает первую ошибку f.
+func (a *Auth) MapSecrets(f func(Secret) (Secret, error)) (*Auth, error) {
+	if a == nil {
+		return nil, nil
+	}
+	var err error
+	apply := func(value Secret) Secret {
+		if value == "" || err != nil {
+			return value
+		}
+		value, err = f(value)
+		return value
+	}
+	result := &Auth{Bearer: apply(a.Bearer)}
+	if a.Headers != nil {
+		result.Headers = make(map[string]Secret, len(a.Headers))
+		for name, value := range a.Headers {
+			result.Headers[name] = apply(value)
+		}
+	}
+	if a.Basic != nil {
+		result.Basic = &BasicAuth{Username: a.Basic.Username, Password: apply(a.Basic.Password)}
+	}
+	if a.Cookies != nil {
+		result.Cookies = make(map[string]Secret, len(a.Cookies))
+		for name, value := range a.Cookies {
+			result.Cookies[name] = apply(value)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// CookieHeader - значение заголовка Cookie, cookies отсортированы по имени
+func (a *Auth) CookieHeader() string {
+	names := make([]string, 0, len(a.Cookies))
+	for name := range a.Cookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+string(a.Cookies[name]))
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// String описывает состав данных без значений, для логов
+func (a *Auth) String() string {
+	if a == nil {
+		return "<nil>"
+	}
+	var parts []string
+	if len(a.Headers) > 0 {
+		names := make([]string, 0, len(a.Headers))
+		for name := range a.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts = append(parts, "headers:"+strings.Join(names, ","))
+	}
+	if a.Bearer != "" {
+		parts = append(parts, "bearer")
+	}
+	if a.Basic != nil {
+		parts = append(parts, "basic:"+a.Basic.Username)
+	}
+	if len(a.Cookies) > 0 {
+		parts = append(parts, fmt.Sprintf("cookies:%d", len(a.Cookies)))
+	}
+	return "auth{" + strings.Join(parts, " ") + "}"
+}
+
+func (a *Auth) GoString() string {
+	return a.String()
+}
+
+// isToken - имя заголовка или cookie по RFC 7230
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?={}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isToken68 - значение bearer токена по RFC 6750
+func isToken68(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			strings.IndexByte("-._~+/=", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// isHeaderValue - в значении нет переводов строк и управляющих символов
+func isHeaderValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
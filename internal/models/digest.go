@@ -0,0 +1,99 @@
+package models
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Алгоритмы контрольных сумм, которые клиент может передать для ссылки
+const (
+	DigestSha256 = "sha256"
+	DigestSha1   = "sha1"
+	DigestMd5    = "md5"
+)
+
+var ErrBadDigest = errors.New("bad digest")
+
+// digestLen - длина контрольной суммы в hex для каждого алгоритма
+var digestLen = map[string]int{
+	DigestSha256: 64,
+	DigestSha1:   40,
+	DigestMd5:    32,
+}
+
+// ParseDigest разбирает контрольную сумму вида "sha256:<hex>" и возвращает алгоритм и сумму в нижнем регистре
+func ParseDigest(digest string) (string, string, error) {
+	algo, sum, ok := strings.Cut(digest, ":")
+	if !ok {
+		return "", "", fmt.Errorf("%w: %q, expected algo:hex", ErrBadDigest, digest)
+	}
+	algo = strings.ToLower(algo)
+	size, ok := digestLen[algo]
+	if !ok {
+		return "", "", fmt.Errorf("%w: unsupported algorithm %q", ErrBadDigest, algo)
+	}
+	sum = strings.ToLower(sum)
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != size {
+		return "", "", fmt.Errorf("%w: bad %s sum %q", ErrBadDigest, algo, sum)
+	}
+	return algo, sum, nil
+}
+
+// LinkRequest - ссылка в запросе. В JSON это строка с адресом или объект
+// с адресом, ожидаемой контрольной суммой, размером файла и учетными данными.
+type LinkRequest struct {
+	Url    string `json:"url"`
+	Digest string `json:"digest,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Auth   *Auth  `json:"auth,omitempty"`
+}
+
+func (l *LinkRequest) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		*l = LinkRequest{Url: url}
+		return nil
+	}
+
+	// отдельный тип без метода UnmarshalJSON, иначе рекурсия
+	type plain LinkRequest
+	var link plain
+	err := json.Unmarshal(data, &link)
+	if err != nil {
+		return err
+	}
+	if link.Url == "" {
+		return errors.New("link url is empty")
+	}
+	if link.Size < 0 {
+		return fmt.Errorf("bad size %d of link %s", link.Size, RedactedUrl(link.Url))
+	}
+	if link.Digest != "" {
+		algo, sum, err := ParseDigest(link.Digest)
+		if err != nil {
+			return err
+		}
+		link.Digest = algo + ":" + sum
+	}
+	err = link.Auth.Validate()
+	if err != nil {
+		return err
+	}
+	*l = LinkRequest(link)
+	return nil
+}
+
+// NewDownloadJob - задание загрузчику на скачивание ссылки задачи
+func NewDownloadJob(taskId string, link Link) DownloadJob {
+	return DownloadJob{
+		TaskId:         taskId,
+		Index:          link.Index,
+		Url:            link.Url,
+		ExpectedDigest: link.ExpectedDigest,
+		ExpectedSize:   link.ExpectedSize,
+		Auth:           link.Auth,
+	}
+}
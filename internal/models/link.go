@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+const (
+	LinkStatePending   = "Pending"
+	LinkStateDone      = "Done"
+	LinkStateFailed    = "Failed"
+	LinkStateCancelled = "Cancelled"
+)
+
+// Классы ошибок скачивания ссылки
+const (
+	ErrorClassNetwork    = "network"
+	ErrorClassHttpStatus = "http_status"
+	ErrorClassFtpStatus  = "ftp_status"
+	// ErrorClassNotFound - источник сообщил, что файла нет
+	ErrorClassNotFound = "not_found"
+	ErrorClassType     = "type_not_allowed"
+	// ErrorClassTypeMismatch - содержимое не соответствует заявленному типу или расширению
+	ErrorClassTypeMismatch = "type_mismatch"
+	// ErrorClassBlocked - адрес запрещен: внутренняя сеть, схема, порт или хост
+	ErrorClassBlocked = "blocked"
+	// ErrorClassChecksum - размер или контрольная сумма файла не совпали с переданными клиентом
+	ErrorClassChecksum    = "checksum_mismatch"
+	ErrorClassTooLarge    = "too_large"
+	ErrorClassCancelled   = "cancelled"
+	ErrorClassArchive     = "archive"
+	ErrorClassInterrupted = "interrupted"
+	ErrorClassInternal    = "internal"
+	// Таймауты: соединения, согласования TLS, ожидания заголовков, паузы в получении тела
+	// и общего времени на ссылку
+	ErrorClassTimeoutDial    = "timeout_dial"
+	ErrorClassTimeoutTLS     = "timeout_tls"
+	ErrorClassTimeoutHeaders = "timeout_headers"
+	ErrorClassTimeoutIdle    = "timeout_idle"
+	ErrorClassTimeoutTotal   = "timeout_total"
+	// ErrorClassRedirect - редирект запрещен политикой или их слишком много
+	ErrorClassRedirect = "redirect"
+)
+
+// Link - запись о ссылке задачи. Index - порядковый номер ссылки в задаче,
+// одинаковые URL хранятся отдельными записями. Transient - последняя ошибка была временной,
+// но попытки скачивания закончились. Auth - учетные данные задачи вместе с данными ссылки,
+// в ответах клиенту их значения скрыты.
+type Link struct {
+	Index       int    `json:"index"`
+	Url         string `json:"url"`
+	FinalUrl    string `json:"final_url,omitempty"`
+	State       string `json:"state"`
+	HttpCode    int    `json:"http_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// Redirects - адреса, ответившие редиректом, по порядку от Url к FinalUrl
+	Redirects []Redirect `json:"redirects,omitempty"`
+	// DeclaredType - тип, заявленный сервером, если он отличается от определенного по содержимому
+	DeclaredType string `json:"declared_type,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	Sha256       string `json:"sha256,omitempty"`
+	// ExpectedDigest и ExpectedSize переданы клиентом, Digest - посчитанная тем же алгоритмом сумма
+	ExpectedDigest string     `json:"expected_digest,omitempty"`
+	ExpectedSize   int64      `json:"expected_size,omitempty"`
+	Digest         string     `json:"digest,omitempty"`
+	Auth           *Auth      `json:"auth,omitempty"`
+	FileName       string     `json:"file_name,omitempty"`
+	Attempts       int        `json:"attempts,omitempty"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	ErrorClass     string     `json:"error_class,omitempty"`
+	Transient      bool       `json:"transient,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// Redirect - адрес, который ответил редиректом, и код ответа
+type Redirect struct {
+	Url        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+}
+
+// Finished - ссылка обработана и больше не изменится
+func (l Link) Finished() bool {
+	return l.State != LinkStatePending
+}
@@ -1,49 +1,158 @@
 package models
 
+import "time"
+
 const (
-	StatusIdle       = "Idle"
-	StatusProcessing = "Processing"
-	StatusDone       = "Done"
+	ArchiveFormatZip   = "zip"
+	ArchiveFormatTarGz = "tar.gz"
 )
 
 type Task struct {
 	TaskId        string            `json:"task_id"`
-	Links         []string          `json:"links,omitempty"`
-	LinksStatuses map[string]string `json:"links_statuses,omitempty"`
-	LinksError    map[string]string `json:"links_error,omitempty"`
+	Name          string            `json:"name,omitempty"`
+	Links         []Link            `json:"links,omitempty"`
 	Status        string            `json:"status"`
 	ZipPath       string            `json:"zip_path,omitempty"`
+	ArchiveFormat string            `json:"archive_format,omitempty"`
+	CallbackUrl   string            `json:"callback_url,omitempty"`
+	ClientId      string            `json:"client_id,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Auth          *Auth             `json:"auth,omitempty"`
+	AuthLost      bool              `json:"auth_lost,omitempty"`
+	Transitions   []Transition      `json:"transitions,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
 }
 
 type Status struct {
 	TaskId        string            `json:"task_id"`
+	Name          string            `json:"name,omitempty"`
 	Status        string            `json:"status"`
-	LinksStatuses map[string]string `json:"links_statuses,omitempty"`
-	LinksError    map[string]string `json:"links_error,omitempty"`
+	Links         []Link            `json:"links,omitempty"`
 	ZipPath       string            `json:"url,omitempty"`
+	ArchiveFormat string            `json:"archive_format,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Auth          *Auth             `json:"auth,omitempty"`
+	Transitions   []Transition      `json:"transitions,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// TaskFilter - условия выборки задач, пустые поля не ограничивают выборку
+type TaskFilter struct {
+	Statuses    []string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Labels      map[string]string
+	Cursor      string
+	Limit       int
+	// ClientId - задачи только этого клиента, задается сервером, а не параметрами запроса
+	ClientId string
+}
+
+type TaskList struct {
+	Tasks      []*Status `json:"tasks"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// NewStatus собирает ответ о состоянии задачи, путь к архиву отдается только для задачи, завершенной с архивом.
+// Учетные данные задачи и ссылок, в том числе пароли в адресах, отдаются без значений.
+func NewStatus(task Task) *Status {
+	links := make([]Link, len(task.Links))
+	copy(links, task.Links)
+	for i := range links {
+		links[i].Url = RedactedUrl(links[i].Url)
+		links[i].Auth = links[i].Auth.Redacted()
+	}
+	result := &Status{
+		TaskId:        task.TaskId,
+		Name:          task.Name,
+		Links:         links,
+		Status:        task.Status,
+		ArchiveFormat: task.ArchiveFormat,
+		Labels:        task.Labels,
+		Auth:          task.Auth.Redacted(),
+		Transitions:   task.Transitions,
+		CreatedAt:     task.CreatedAt,
+		UpdatedAt:     task.UpdatedAt,
+	}
+	// у Failed архива нет, даже если он был до проверки при восстановлении
+	if task.Status == StatusDone || task.Status == StatusPartiallyDone {
+		result.ZipPath = task.ZipPath
+	}
+	return result
+}
+
+// ArchiveExt возвращает расширение файла архива для формата, по умолчанию .zip
+func ArchiveExt(format string) string {
+	if format == ArchiveFormatTarGz {
+		return ".tar.gz"
+	}
+	return ".zip"
+}
+
+type CreateTaskRequest struct {
+	Links         []LinkRequest     `json:"links"`
+	Name          string            `json:"name,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	ArchiveFormat string            `json:"archive_format,omitempty"`
+	CallbackUrl   string            `json:"callback_url,omitempty"`
+	Auth          *Auth             `json:"auth,omitempty"`
 }
 
 type AddLinksRequest struct {
-	TaskId string   `json:"task_id"`
-	Links  []string `json:"links"`
+	TaskId string        `json:"task_id"`
+	Links  []LinkRequest `json:"links"`
 }
 
 type DownloadJob struct {
 	TaskId string `json:"task_id"`
+	Index  int    `json:"index"`
 	Url    string `json:"url"`
-	Err    error  `json:"error,omitempty"`
+	Auth   *Auth  `json:"auth,omitempty"`
+	// ExpectedDigest - контрольная сумма вида sha256:<hex>, ExpectedSize - размер файла, если их передал клиент
+	ExpectedDigest string `json:"expected_digest,omitempty"`
+	ExpectedSize   int64  `json:"expected_size,omitempty"`
+	Err            error  `json:"error,omitempty"`
 }
 
 type ZipJob struct {
-	TaskId         string  `json:"task_id"`
-	Url            string  `json:"url"`
-	Data           *[]byte `json:"data"`
-	ResponseStatus string  `json:"response_status"`
-	FileName       string  `json:"file_name"`
-	Err            error   `json:"error"`
+	TaskId      string `json:"task_id"`
+	Index       int    `json:"index"`
+	Url         string `json:"url"`
+	FinalUrl    string `json:"final_url"`
+	SpoolPath   string `json:"spool_path"`
+	HttpCode    int    `json:"http_code"`
+	ContentType string `json:"content_type"`
+	// Redirects - адреса, ответившие редиректом, по порядку от Url к FinalUrl
+	Redirects []Redirect `json:"redirects"`
+	// DeclaredType - тип из заголовка Content-Type, ContentType - определенный по содержимому
+	DeclaredType string `json:"declared_type"`
+	Size         int64  `json:"size"`
+	Sha256       string `json:"sha256"`
+	// Digest - контрольная сумма файла алгоритмом из ExpectedDigest задания
+	Digest     string    `json:"digest"`
+	Attempts   int       `json:"attempts"`
+	FileName   string    `json:"file_name"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	ErrorClass string    `json:"error_class"`
+	Transient  bool      `json:"transient"`
+	Err        error     `json:"error"`
 }
 
 type ValueAndError struct {
 	Value interface{}
 	Err   error
 }
+
+// IdempotencyRecord - сохраненный ответ на запрос с заголовком Idempotency-Key.
+// StatusCode == 0 означает, что запрос еще выполняется.
+type IdempotencyRecord struct {
+	Key         string    `json:"key"`
+	RequestHash string    `json:"request_hash"`
+	StatusCode  int       `json:"status_code"`
+	ContentType string    `json:"content_type,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+const (
+	StatusIdle          = "Idle"
+	StatusProcessing    = "Processing"
+	StatusDone          = "Done"
+	StatusPartiallyDone = "PartiallyDone"
+	StatusFailed        = "Failed"
+	StatusCancelled     = "Cancelled"
+	StatusExpired       = "Expired"
+)
+
+// Transition - запись о смене статуса задачи
+type Transition struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+// transitions - допустимые переходы между статусами задачи.
+// Processing -> Processing разрешен: к задаче можно добавлять ссылки,
+// а при восстановлении после рестарта задача запускается заново.
+var transitions = map[string][]string{
+	StatusIdle:          {StatusProcessing, StatusCancelled, StatusExpired},
+	StatusProcessing:    {StatusProcessing, StatusDone, StatusPartiallyDone, StatusFailed, StatusCancelled, StatusExpired},
+	StatusDone:          {StatusFailed, StatusExpired},
+	StatusPartiallyDone: {StatusFailed, StatusExpired},
+	StatusFailed:        {StatusExpired},
+	StatusCancelled:     {StatusExpired},
+	StatusExpired:       {},
+}
+
+// CanTransition проверяет, можно ли перевести задачу из статуса from в статус to
+func CanTransition(from, to string) bool {
+	for _, status := range transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive - задача еще не обработана и занимает слот активных задач
+func IsActive(status string) bool {
+	return status == StatusIdle || status == StatusProcessing
+}
+
+// IsFinished - обработка задачи завершена, архив (если есть) больше не изменится
+func IsFinished(status string) bool {
+	return status == StatusDone || status == StatusPartiallyDone || status == StatusFailed
+}
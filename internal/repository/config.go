@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+	"github.com/JonnyShabli/23.07.2025/pkg/logster"
+)
+
+const (
+	StorageTypeMemory = "memory"
+	StorageTypeFile   = "file"
+)
+
+const (
+	LimitScopeGlobal = "global"
+	LimitScopeClient = "client"
+)
+
+type StorageConfig struct {
+	Type string `yaml:"type"`
+	Path string `yaml:"path"`
+	// MaxActiveTasks - общее число задач в статусах Idle и Processing, по умолчанию 3
+	MaxActiveTasks int `yaml:"max_active_tasks"`
+	// MaxActivePerClient - число активных задач одного клиента, 0 - без ограничения
+	MaxActivePerClient int `yaml:"max_active_per_client"`
+	// RetryAfter - через сколько клиенту предлагается повторить запрос при превышении лимита, по умолчанию 30s
+	RetryAfter time.Duration `yaml:"retry_after"`
+	// IdempotencyTTL - сколько хранится ответ на запрос с Idempotency-Key, по умолчанию 24h
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl"`
+	// SecretKey - ключ AES-256 в base64, которым в файле шифруются учетные данные задач.
+	// Без ключа учетные данные на диск не пишутся и не переживают перезапуск.
+	SecretKey models.Secret `yaml:"secret_key"`
+}
+
+// LimitError возвращается AddTask, когда превышен лимит активных задач
+type LimitError struct {
+	Scope      string
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s limit %d reached", serverBusyErr, e.Scope, e.Limit)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrTooManyTasks
+}
+
+// AsLimitError достает LimitError из цепочки ошибок
+func AsLimitError(err error) (*LimitError, bool) {
+	var limitErr *LimitError
+	ok := errors.As(err, &limitErr)
+	return limitErr, ok
+}
+
+// New создает хранилище указанного в конфиге типа, по умолчанию - в памяти
+func New(cfg StorageConfig, logger logster.Logger) (StorageInterface, error) {
+	switch cfg.Type {
+	case "", StorageTypeMemory:
+		return NewStorage(cfg, logger), nil
+	case StorageTypeFile:
+		return NewFileStorage(cfg, logger)
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+	"github.com/JonnyShabli/23.07.2025/pkg/logster"
+)
+
+const snapshotVersion = 1
+
+// snapshot - формат файла, в котором FileStorage хранит задачи
+type snapshot struct {
+	Version     int                        `json:"version"`
+	Tasks       []models.Task              `json:"tasks"`
+	Idempotency []models.IdempotencyRecord `json:"idempotency,omitempty"`
+}
+
+// FileStorage хранит задачи в памяти, как Storage, но после каждого изменения
+// сбрасывает их на диск, поэтому задачи переживают перезапуск сервиса.
+type FileStorage struct {
+	*Storage
+	path    string
+	secrets *secretBox
+	fileMu  sync.Mutex
+}
+
+func NewFileStorage(cfg StorageConfig, logger logster.Logger) (*FileStorage, error) {
+	path := cfg.Path
+	if path == "" {
+		return nil, errors.New("file storage path is empty")
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0775)
+	if err != nil {
+		return nil, fmt.Errorf("create storage dir failed: %w", err)
+	}
+
+	secrets, err := newSecretBox(string(cfg.SecretKey))
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStorage{
+		Storage: NewStorage(cfg, logger),
+		path:    path,
+		secrets: secrets,
+	}
+	err = fs.load()
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (f *FileStorage) AddTask(ctx context.Context, clientId string) (string, error) {
+	id, err := f.Storage.AddTask(ctx, clientId)
+	if err != nil {
+		return "", err
+	}
+	f.save("AddTask")
+	return id, nil
+}
+
+func (f *FileStorage) CreateTask(ctx context.Context, task models.Task, links []models.LinkRequest) (models.Task, error) {
+	created, err := f.Storage.CreateTask(ctx, task, links)
+	if err != nil {
+		return models.Task{}, err
+	}
+	f.save("CreateTask")
+	return created, nil
+}
+
+func (f *FileStorage) AddLinks(ctx context.Context, links []models.LinkRequest, id string) ([]models.Link, error) {
+	added, err := f.Storage.AddLinks(ctx, links, id)
+	if err != nil {
+		return nil, err
+	}
+	f.save("AddLinks")
+	return added, nil
+}
+
+func (f *FileStorage) UpdateLink(ctx context.Context, id string, link models.Link) error {
+	err := f.Storage.UpdateLink(ctx, id, link)
+	if err != nil {
+		return err
+	}
+	f.save("UpdateLink")
+	return nil
+}
+
+func (f *FileStorage) AddZip(ctx context.Context, data models.Task, id string) error {
+	err := f.Storage.AddZip(ctx, data, id)
+	if err != nil {
+		return err
+	}
+	f.save("AddZip")
+	return nil
+}
+
+func (f *FileStorage) DeleteTask(ctx context.Context, id string) error {
+	err := f.Storage.DeleteTask(ctx, id)
+	if err != nil {
+		return err
+	}
+	f.save("DeleteTask")
+	return nil
+}
+
+func (f *FileStorage) SetStatus(ctx context.Context, id string, status string) error {
+	err := f.Storage.SetStatus(ctx, id, status)
+	if err != nil {
+		return err
+	}
+	f.save("SetStatus")
+	return nil
+}
+
+func (f *FileStorage) ReplaceStatus(ctx context.Context, id string, from, status string) error {
+	err := f.Storage.ReplaceStatus(ctx, id, from, status)
+	if err != nil {
+		return err
+	}
+	f.save("ReplaceStatus")
+	return nil
+}
+
+func (f *FileStorage) SaveIdempotency(ctx context.Context, record models.IdempotencyRecord) error {
+	err := f.Storage.SaveIdempotency(ctx, record)
+	if err != nil {
+		return err
+	}
+	f.save("SaveIdempotency")
+	return nil
+}
+
+func (f *FileStorage) DeleteIdempotency(ctx context.Context, key string) error {
+	err := f.Storage.DeleteIdempotency(ctx, key)
+	if err != nil {
+		return err
+	}
+	f.save("DeleteIdempotency")
+	return nil
+}
+
+// save сбрасывает хранилище на диск после изменения op. Изменение в памяти уже видно
+// остальным, поэтому ошибка записи только логируется: файл догонит память при следующем
+// успешном сбросе, а если сервис перезапустится раньше, изменения будут потеряны.
+func (f *FileStorage) save(op string) {
+	err := f.flush()
+	if err != nil {
+		f.logger.WithError(err).Errorf("%s: flush storage file %s failed", op, f.path)
+	}
+}
+
+// load читает задачи из файла, отсутствие файла не считается ошибкой
+func (f *FileStorage) load() error {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		f.logger.Infof("storage file %s not found, starting with empty storage", f.path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read storage file %s failed: %w", f.path, err)
+	}
+
+	var snap snapshot
+	err = json.Unmarshal(data, &snap)
+	if err != nil {
+		return fmt.Errorf("decode storage file %s failed: %w", f.path, err)
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported storage file %s version %d", f.path, snap.Version)
+	}
+
+	now := time.Now()
+	for _, task := range snap.Tasks {
+		f.db.Store(task.TaskId, f.secrets.openTask(task))
+	}
+	for _, record := range snap.Idempotency {
+		// незавершенные запросы не переживают рестарт
+		if record.StatusCode != 0 && now.Before(record.ExpiresAt) {
+			f.keys.Store(record.Key, record)
+		}
+	}
+	f.logger.Infof("loaded %d tasks from %s", len(snap.Tasks), f.path)
+	return nil
+}
+
+// flush атомарно перезаписывает файл хранилища текущим состоянием:
+// пишем во временный файл и переименовываем его поверх старого
+func (f *FileStorage) flush() error {
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	snap := snapshot{
+		Version: snapshotVersion,
+		Tasks:   make([]models.Task, 0),
+	}
+	var err error
+	f.db.Range(func(k, v interface{}) bool {
+		var task models.Task
+		task, err = f.secrets.sealTask(v.(models.Task))
+		snap.Tasks = append(snap.Tasks, task)
+		return err == nil
+	})
+	if err != nil {
+		return fmt.Errorf("seal task secrets failed: %w", err)
+	}
+	f.keys.Range(func(k, v interface{}) bool {
+		if record := v.(models.IdempotencyRecord); record.StatusCode != 0 {
+			snap.Idempotency = append(snap.Idempotency, record)
+		}
+		return true
+	})
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode storage snapshot failed: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp storage file failed: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp storage file failed: %w", err)
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync temp storage file failed: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("close temp storage file failed: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), f.path)
+	if err != nil {
+		return fmt.Errorf("replace storage file failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+const defaultIdempotencyTTL = 24 * time.Hour
+
+var (
+	ErrIdempotencyConflict   = errors.New("idempotency key reused with different request")
+	ErrIdempotencyInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+// BeginIdempotency резервирует ключ за запросом. Если по ключу уже сохранен ответ
+// на такой же запрос, он возвращается для повтора, иначе возвращается nil
+// и после выполнения запроса нужно вызвать SaveIdempotency или DeleteIdempotency.
+func (s *Storage) BeginIdempotency(ctx context.Context, key, requestHash string) (*models.IdempotencyRecord, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		s.logger.WithError(ctx.Err()).Errorf("BeginIdempotency: context expire")
+		return nil, ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if v, ok := s.keys.Load(key); ok {
+		record := v.(models.IdempotencyRecord)
+		if now.Before(record.ExpiresAt) {
+			switch {
+			case record.RequestHash != requestHash:
+				return nil, ErrIdempotencyConflict
+			case record.StatusCode == 0:
+				return nil, ErrIdempotencyInProgress
+			default:
+				s.logger.Infof("BeginIdempotency: replay response for key %s", key)
+				return &record, nil
+			}
+		}
+	}
+
+	s.keys.Store(key, models.IdempotencyRecord{
+		Key:         key,
+		RequestHash: requestHash,
+		ExpiresAt:   now.Add(s.idempotencyTTL),
+	})
+	return nil, nil
+}
+
+// SaveIdempotency сохраняет ответ на запрос, зарезервировавший ключ
+func (s *Storage) SaveIdempotency(ctx context.Context, record models.IdempotencyRecord) error {
+	select {
+	default:
+	case <-ctx.Done():
+		s.logger.WithError(ctx.Err()).Errorf("SaveIdempotency: context expire")
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.ExpiresAt = time.Now().Add(s.idempotencyTTL)
+	s.keys.Store(record.Key, record)
+
+	// заодно чистим ключи с истекшим сроком хранения
+	now := time.Now()
+	s.keys.Range(func(k, v interface{}) bool {
+		if now.After(v.(models.IdempotencyRecord).ExpiresAt) {
+			s.keys.Delete(k)
+		}
+		return true
+	})
+	return nil
+}
+
+// DeleteIdempotency освобождает ключ, например, если запрос завершился временной ошибкой
+func (s *Storage) DeleteIdempotency(ctx context.Context, key string) error {
+	select {
+	default:
+	case <-ctx.Done():
+		s.logger.WithError(ctx.Err()).Errorf("DeleteIdempotency: context expire")
+		return ctx.Err()
+	}
+
+	s.keys.Delete(key)
+	return nil
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListTasks возвращает страницу задач, отсортированных по времени создания, и курсор следующей страницы.
+// Курсор пустой, если страница последняя.
+func (s *Storage) ListTasks(ctx context.Context, filter models.TaskFilter) ([]models.Task, string, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		s.logger.WithError(ctx.Err()).Errorf("ListTasks: context expire")
+		return nil, "", ctx.Err()
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	var after cursor
+	if filter.Cursor != "" {
+		var err error
+		after, err = decodeCursor(filter.Cursor)
+		if err != nil {
+			s.logger.WithError(err).Errorf("ListTasks: bad cursor")
+			return nil, "", err
+		}
+	}
+
+	tasks, err := s.GetTasks(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return cursorOf(tasks[i]).less(cursorOf(tasks[j]))
+	})
+
+	page := make([]models.Task, 0, limit)
+	var next string
+	for _, task := range tasks {
+		if filter.Cursor != "" && !after.less(cursorOf(task)) {
+			continue
+		}
+		if !matchFilter(task, filter) {
+			continue
+		}
+		if len(page) == limit {
+			next = cursorOf(page[len(page)-1]).encode()
+			break
+		}
+		page = append(page, task)
+	}
+
+	s.logger.Infof("ListTasks: %d tasks found", len(page))
+	return page, next, nil
+}
+
+func matchFilter(task models.Task, filter models.TaskFilter) bool {
+	if filter.ClientId != "" && task.ClientId != filter.ClientId {
+		return false
+	}
+	if len(filter.Statuses) > 0 {
+		found := false
+		for _, status := range filter.Statuses {
+			if strings.EqualFold(status, task.Status) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !filter.CreatedFrom.IsZero() && task.CreatedAt.Before(filter.CreatedFrom) {
+		return false
+	}
+	if !filter.CreatedTo.IsZero() && !task.CreatedAt.Before(filter.CreatedTo) {
+		return false
+	}
+	for k, v := range filter.Labels {
+		if task.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// cursor - позиция задачи в выдаче: время создания и Id для однозначного порядка
+type cursor struct {
+	createdAt int64
+	taskId    string
+}
+
+func cursorOf(task models.Task) cursor {
+	return cursor{createdAt: task.CreatedAt.UnixNano(), taskId: task.TaskId}
+}
+
+func (c cursor) less(other cursor) bool {
+	if c.createdAt != other.createdAt {
+		return c.createdAt < other.createdAt
+	}
+	return c.taskId < other.taskId
+}
+
+func (c cursor) encode() string {
+	raw := strconv.FormatInt(c.createdAt, 10) + ":" + c.taskId
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	ts, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return cursor{}, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return cursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	return cursor{createdAt: nanos, taskId: id}, nil
+}
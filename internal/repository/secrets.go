@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+// sealedPrefix отличает зашифрованное значение от открытого
+const sealedPrefix = "enc:"
+
+var ErrSecretNotSealed = errors.New("secret is not sealed")
+
+// secretBox шифрует учетные данные задач в файле хранилища AES-256-GCM.
+// nil - ключ не задан, учетные данные в файл не пишутся.
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+func newSecretBox(key string) (*secretBox, error) {
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("storage secret key must be 32 bytes encoded in base64")
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("create storage cipher failed: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create storage cipher failed: %w", err)
+	}
+	return &secretBox{aead: aead}, nil
+}
+
+func (b *secretBox) seal(value models.Secret) (models.Secret, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("generate nonce failed: %w", err)
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(value), nil)
+	return models.Secret(sealedPrefix + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func (b *secretBox) open(value models.Secret) (models.Secret, error) {
+	encoded, ok := strings.CutPrefix(string(value), sealedPrefix)
+	if b == nil || !ok {
+		return "", ErrSecretNotSealed
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", ErrSecretNotSealed
+	}
+	nonceSize := b.aead.NonceSize()
+	plain, err := b.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("open secret failed: %w", err)
+	}
+	return models.Secret(plain), nil
+}
+
+// sealTask - копия задачи для записи в файл: учетные данные зашифрованы,
+// а без ключа удалены, и задача помечена AuthLost
+func (b *secretBox) sealTask(task models.Task) (models.Task, error) {
+	if !hasAuth(task) {
+		return task, nil
+	}
+	if b == nil {
+		return withoutAuth(task), nil
+	}
+	return mapTaskSecrets(task, b.seal)
+}
+
+// openTask расшифровывает учетные данные задачи из файла. Если ключ сменился или не задан,
+// данные не восстановить, и задача помечается AuthLost.
+func (b *secretBox) openTask(task models.Task) models.Task {
+	if !hasAuth(task) {
+		return task
+	}
+	opened, err := mapTaskSecrets(task, b.open)
+	if err != nil {
+		return withoutAuth(task)
+	}
+	return opened
+}
+
+func hasAuth(task models.Task) bool {
+	if task.Auth != nil {
+		return true
+	}
+	for _, link := range task.Links {
+		if link.Auth != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutAuth(task models.Task) models.Task {
+	task.Auth = nil
+	task.AuthLost = true
+	links := make([]models.Link, len(task.Links))
+	copy(links, task.Links)
+	for i := range links {
+		links[i].Auth = nil
+	}
+	task.Links = links
+	return task
+}
+
+// mapTaskSecrets применяет f к учетным данным задачи и ее ссылок, не меняя исходную задачу
+func mapTaskSecrets(task models.Task, f func(models.Secret) (models.Secret, error)) (models.Task, error) {
+	var err error
+	task.Auth, err = task.Auth.MapSecrets(f)
+	if err != nil {
+		return task, err
+	}
+	links := make([]models.Link, len(task.Links))
+	copy(links, task.Links)
+	for i := range links {
+		links[i].Auth, err = links[i].Auth.MapSecrets(f)
+		if err != nil {
+			return task, err
+		}
+	}
+	task.Links = links
+	return task, nil
+}
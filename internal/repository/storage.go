@@ -5,34 +5,78 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/JonnyShabli/23.07.2025/internal/models"
 	"github.com/JonnyShabli/23.07.2025/pkg/logster"
 	"github.com/google/uuid"
 )
 
-const serverBusyErr = "to many active tasks"
+const (
+	serverBusyErr         = "to many active tasks"
+	defaultMaxActiveTasks = 3
+	defaultRetryAfter     = 30 * time.Second
+)
+
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	ErrTaskExpired  = errors.New("task expired")
+	ErrTooManyTasks = errors.New(serverBusyErr)
+	ErrNoLinks      = errors.New("links list is empty")
+	ErrLinkNotFound = errors.New("link not found")
+)
 
 type StorageInterface interface {
-	AddTask(ctx context.Context) (string, error)
+	AddTask(ctx context.Context, clientId string) (string, error)
+	CreateTask(ctx context.Context, task models.Task, links []models.LinkRequest) (models.Task, error)
 	GetTask(ctx context.Context, id string) (models.Task, error)
-	AddLinks(ctx context.Context, links []string, id string) (int, error)
+	AddLinks(ctx context.Context, links []models.LinkRequest, id string) ([]models.Link, error)
+	UpdateLink(ctx context.Context, id string, link models.Link) error
 	AddZip(ctx context.Context, data models.Task, id string) error
+	GetTasks(ctx context.Context) ([]models.Task, error)
+	DeleteTask(ctx context.Context, id string) error
+	SetStatus(ctx context.Context, id string, status string) error
+	ReplaceStatus(ctx context.Context, id string, from, status string) error
+	BeginIdempotency(ctx context.Context, key, requestHash string) (*models.IdempotencyRecord, error)
+	SaveIdempotency(ctx context.Context, record models.IdempotencyRecord) error
+	DeleteIdempotency(ctx context.Context, key string) error
+	ListTasks(ctx context.Context, filter models.TaskFilter) ([]models.Task, string, error)
 }
 type Storage struct {
-	mu     sync.RWMutex
-	db     sync.Map
-	logger logster.Logger
+	mu                 sync.RWMutex
+	db                 sync.Map
+	keys               sync.Map
+	logger             logster.Logger
+	maxActive          int
+	maxActivePerClient int
+	retryAfter         time.Duration
+	idempotencyTTL     time.Duration
 }
 
-func NewStorage(logger logster.Logger) *Storage {
+func NewStorage(cfg StorageConfig, logger logster.Logger) *Storage {
+	maxActive := cfg.MaxActiveTasks
+	if maxActive <= 0 {
+		maxActive = defaultMaxActiveTasks
+	}
+	retryAfter := cfg.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	idempotencyTTL := cfg.IdempotencyTTL
+	if idempotencyTTL <= 0 {
+		idempotencyTTL = defaultIdempotencyTTL
+	}
 	return &Storage{
-		db:     sync.Map{},
-		logger: logger.WithField("Layer", "Repository"),
+		db:                 sync.Map{},
+		logger:             logger.WithField("Layer", "Repository"),
+		maxActive:          maxActive,
+		maxActivePerClient: cfg.MaxActivePerClient,
+		retryAfter:         retryAfter,
+		idempotencyTTL:     idempotencyTTL,
 	}
 }
 
-func (s *Storage) AddTask(ctx context.Context) (string, error) {
+func (s *Storage) AddTask(ctx context.Context, clientId string) (string, error) {
 	select {
 	default:
 	case <-ctx.Done():
@@ -42,28 +86,25 @@ func (s *Storage) AddTask(ctx context.Context) (string, error) {
 
 	doneCh := make(chan models.ValueAndError)
 	defer close(doneCh)
-	var activeCount int
 	go func() {
 		var result models.ValueAndError
 		s.mu.Lock()
-		s.db.Range(func(k, v interface{}) bool {
-			if v.(models.Task).Status != models.StatusDone {
-				activeCount++
-				fmt.Println(activeCount)
-			}
-			return true
-		})
-		s.mu.Unlock()
-		if activeCount >= 3 {
-			result.Err = errors.New(serverBusyErr)
+		defer s.mu.Unlock()
+		err := s.checkLimits(clientId)
+		if err != nil {
+			result.Err = err
 			doneCh <- result
 			return
 		}
 
+		now := time.Now()
 		task := models.Task{
-			TaskId: uuid.New().String(),
-			Links:  make([]string, 0),
-			Status: models.StatusIdle,
+			TaskId:    uuid.New().String(),
+			Links:     make([]models.Link, 0),
+			Status:    models.StatusIdle,
+			ClientId:  clientId,
+			CreatedAt: now,
+			UpdatedAt: now,
 		}
 		s.db.Store(task.TaskId, task)
 
@@ -87,6 +128,65 @@ func (s *Storage) AddTask(ctx context.Context) (string, error) {
 	}
 }
 
+// CreateTask атомарно создает задачу сразу со ссылками в статусе Processing
+func (s *Storage) CreateTask(ctx context.Context, task models.Task, links []models.LinkRequest) (models.Task, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		s.logger.WithError(ctx.Err()).Errorf("CreateTask: context expire")
+		return models.Task{}, ctx.Err()
+	}
+	if len(links) == 0 {
+		return models.Task{}, ErrNoLinks
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.checkLimits(task.ClientId)
+	if err != nil {
+		s.logger.WithError(err).Errorf("CreateTask: task not created")
+		return models.Task{}, err
+	}
+
+	now := time.Now()
+	task.TaskId = uuid.New().String()
+	task.Status = models.StatusIdle
+	task.Links = make([]models.Link, 0, len(links))
+	task.CreatedAt = now
+	for i, l := range links {
+		task.Links = append(task.Links, newLink(i, l, task.Auth))
+	}
+	err = transition(&task, models.StatusProcessing)
+	if err != nil {
+		return models.Task{}, err
+	}
+	s.db.Store(task.TaskId, task)
+	s.logger.Infof("CreateTask: task created with Id: %s and %d links", task.TaskId, len(links))
+	return task, nil
+}
+
+// checkLimits проверяет лимиты активных задач, вызывается под s.mu
+func (s *Storage) checkLimits(clientId string) error {
+	var activeCount, clientCount int
+	s.db.Range(func(k, v interface{}) bool {
+		task := v.(models.Task)
+		if models.IsActive(task.Status) {
+			activeCount++
+			if task.ClientId == clientId {
+				clientCount++
+			}
+		}
+		return true
+	})
+	if activeCount >= s.maxActive {
+		return &LimitError{Scope: LimitScopeGlobal, Limit: s.maxActive, RetryAfter: s.retryAfter}
+	}
+	if s.maxActivePerClient > 0 && clientCount >= s.maxActivePerClient {
+		return &LimitError{Scope: LimitScopeClient, Limit: s.maxActivePerClient, RetryAfter: s.retryAfter}
+	}
+	return nil
+}
+
 func (s *Storage) GetTask(ctx context.Context, id string) (models.Task, error) {
 	select {
 	default:
@@ -100,7 +200,7 @@ func (s *Storage) GetTask(ctx context.Context, id string) (models.Task, error) {
 	go func() {
 		task, ok := s.db.Load(id)
 		if !ok {
-			err := errors.New("task not found")
+			err := ErrTaskNotFound
 			s.logger.WithError(err).Errorf("GetTask: task not found")
 			doneCh <- models.ValueAndError{Value: nil, Err: err}
 			return
@@ -121,7 +221,7 @@ func (s *Storage) GetTask(ctx context.Context, id string) (models.Task, error) {
 		if result.Value == nil {
 			err := errors.New("task and error are nil")
 			s.logger.WithError(err).Errorf("GetTask: task not found")
-			return models.Task{}, errors.New("task not found")
+			return models.Task{}, ErrTaskNotFound
 		}
 
 		s.logger.Infof("GetTask: task found, Id: %s", result.Value.(models.Task).TaskId)
@@ -130,12 +230,25 @@ func (s *Storage) GetTask(ctx context.Context, id string) (models.Task, error) {
 	}
 }
 
-func (s *Storage) AddLinks(ctx context.Context, links []string, id string) (int, error) {
+// newLink - запись о новой ссылке, учетные данные ссылки накладываются на данные задачи
+func newLink(index int, l models.LinkRequest, auth *models.Auth) models.Link {
+	return models.Link{
+		Index:          index,
+		Url:            l.Url,
+		State:          models.LinkStatePending,
+		ExpectedDigest: l.Digest,
+		ExpectedSize:   l.Size,
+		Auth:           auth.Merge(l.Auth),
+	}
+}
+
+// AddLinks добавляет ссылки в задачу и возвращает созданные для них записи
+func (s *Storage) AddLinks(ctx context.Context, links []models.LinkRequest, id string) ([]models.Link, error) {
 	select {
 	default:
 	case <-ctx.Done():
 		s.logger.WithError(ctx.Err()).Errorf("AddLink: context expire")
-		return 0, ctx.Err()
+		return nil, ctx.Err()
 	}
 
 	doneCh := make(chan models.ValueAndError)
@@ -151,28 +264,41 @@ func (s *Storage) AddLinks(ctx context.Context, links []string, id string) (int,
 			return
 		}
 
-		task.Links = append(task.Links, links...)
-		task.Status = models.StatusProcessing
+		if len(links) == 0 {
+			doneCh <- models.ValueAndError{Value: nil, Err: ErrNoLinks}
+			return
+		}
+		err = transition(&task, models.StatusProcessing)
+		if err != nil {
+			doneCh <- models.ValueAndError{Value: nil, Err: err}
+			return
+		}
+		added := make([]models.Link, 0, len(links))
+		for _, l := range links {
+			link := newLink(len(task.Links), l, task.Auth)
+			task.Links = append(task.Links, link)
+			added = append(added, link)
+		}
 		s.db.Store(task.TaskId, task)
-		doneCh <- models.ValueAndError{Value: len(links), Err: nil}
+		doneCh <- models.ValueAndError{Value: added, Err: nil}
 	}()
 
 	select {
 	case <-ctx.Done():
 		s.logger.WithError(ctx.Err()).Errorf("AddLinks: context expire")
-		return 0, ctx.Err()
+		return nil, ctx.Err()
 	case result := <-doneCh:
 		if result.Err != nil {
 			s.logger.WithError(result.Err).Errorf("AddLinks: links not added")
-			return 0, result.Err
+			return nil, result.Err
 		}
 		if result.Value == nil {
 			err := errors.New("links number and error are nil")
 			s.logger.WithError(err).Errorf("AddLinks: links not added")
-			return 0, errors.New("task not found")
+			return nil, errors.New("task not found")
 		}
 		s.logger.Infof("AddLinks: links added, returning")
-		return result.Value.(int), nil
+		return result.Value.([]models.Link), nil
 	}
 }
 
@@ -197,10 +323,12 @@ func (s *Storage) AddZip(ctx context.Context, data models.Task, id string) error
 			return
 		}
 
+		err = transition(&task, data.Status)
+		if err != nil {
+			doneCh <- err
+			return
+		}
 		task.TaskId = id
-		task.Status = data.Status
-		task.LinksError = data.LinksError
-		task.LinksStatuses = data.LinksStatuses
 		task.ZipPath = data.ZipPath
 
 		s.db.Store(task.TaskId, task)
@@ -221,3 +349,128 @@ func (s *Storage) AddZip(ctx context.Context, data models.Task, id string) error
 		return nil
 	}
 }
+
+func (s *Storage) GetTasks(ctx context.Context) ([]models.Task, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		s.logger.WithError(ctx.Err()).Errorf("GetTasks: context expire")
+		return nil, ctx.Err()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tasks := make([]models.Task, 0)
+	s.db.Range(func(k, v interface{}) bool {
+		tasks = append(tasks, v.(models.Task))
+		return true
+	})
+	return tasks, nil
+}
+
+func (s *Storage) DeleteTask(ctx context.Context, id string) error {
+	select {
+	default:
+	case <-ctx.Done():
+		s.logger.WithError(ctx.Err()).Errorf("DeleteTask: context expire")
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.db.LoadAndDelete(id)
+	if !ok {
+		s.logger.WithError(ErrTaskNotFound).Errorf("DeleteTask: task not found")
+		return ErrTaskNotFound
+	}
+	s.logger.Infof("DeleteTask: task deleted, Id: %s", id)
+	return nil
+}
+
+func (s *Storage) SetStatus(ctx context.Context, id string, status string) error {
+	return s.setStatus(ctx, id, "", status)
+}
+
+// ReplaceStatus переводит задачу в статус status, только если она все еще в статусе from:
+// для решений, принятых по прочитанной ранее задаче, которую с тех пор могли изменить
+func (s *Storage) ReplaceStatus(ctx context.Context, id string, from, status string) error {
+	return s.setStatus(ctx, id, from, status)
+}
+
+// setStatus меняет статус задачи, пустой from - из любого статуса
+func (s *Storage) setStatus(ctx context.Context, id string, from, status string) error {
+	select {
+	default:
+	case <-ctx.Done():
+		s.logger.WithError(ctx.Err()).Errorf("SetStatus: context expire")
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, err := s.GetTask(ctx, id)
+	if err != nil {
+		return err
+	}
+	if from != "" && task.Status != from {
+		return fmt.Errorf("%w: task %s is %s, not %s", ErrStatusChanged, id, task.Status, from)
+	}
+	err = transition(&task, status)
+	if err != nil {
+		s.logger.WithError(err).Errorf("SetStatus: status not changed")
+		return err
+	}
+	if status == models.StatusCancelled {
+		cancelLinks(&task)
+	}
+	s.db.Store(task.TaskId, task)
+	s.logger.Infof("SetStatus: task %s moved to %s", id, status)
+	return nil
+}
+
+// cancelLinks помечает незавершенные ссылки отмененной задачи: менеджер архивов
+// отбрасывает их результаты, не обновляя записи о ссылках
+func cancelLinks(task *models.Task) {
+	finishedAt := task.UpdatedAt
+	links := make([]models.Link, len(task.Links))
+	copy(links, task.Links)
+	for i := range links {
+		if links[i].Finished() {
+			continue
+		}
+		links[i].State = models.LinkStateCancelled
+		links[i].ErrorClass = models.ErrorClassCancelled
+		links[i].Error = "task cancelled"
+		links[i].FinishedAt = &finishedAt
+	}
+	task.Links = links
+}
+
+// UpdateLink заменяет запись о ссылке задачи с тем же индексом
+func (s *Storage) UpdateLink(ctx context.Context, id string, link models.Link) error {
+	select {
+	default:
+	case <-ctx.Done():
+		s.logger.WithError(ctx.Err()).Errorf("UpdateLink: context expire")
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, err := s.GetTask(ctx, id)
+	if err != nil {
+		return err
+	}
+	if link.Index < 0 || link.Index >= len(task.Links) {
+		s.logger.WithError(ErrLinkNotFound).Errorf("UpdateLink: bad index %d", link.Index)
+		return ErrLinkNotFound
+	}
+
+	links := make([]models.Link, len(task.Links))
+	copy(links, task.Links)
+	links[link.Index] = link
+	task.Links = links
+	task.UpdatedAt = time.Now()
+	s.db.Store(task.TaskId, task)
+	return nil
+}
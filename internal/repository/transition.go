@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/JonnyShabli/23.07.2025/internal/models"
+)
+
+var ErrIllegalTransition = errors.New("illegal task status transition")
+
+// ErrStatusChanged - ReplaceStatus: статус задачи уже не тот, по которому принималось решение
+var ErrStatusChanged = errors.New("task status changed")
+
+type TransitionError struct {
+	TaskId string
+	From   string
+	To     string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s: task %s can't move from %s to %s", ErrIllegalTransition, e.TaskId, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// transition переводит задачу в новый статус, если это разрешено конечным автоматом
+// статусов, и записывает переход в историю задачи
+func transition(task *models.Task, to string) error {
+	if !models.CanTransition(task.Status, to) {
+		return &TransitionError{TaskId: task.TaskId, From: task.Status, To: to}
+	}
+	now := time.Now()
+	task.Transitions = append(task.Transitions, models.Transition{
+		From: task.Status,
+		To:   to,
+		At:   now,
+	})
+	task.Status = to
+	task.UpdatedAt = now
+	return nil
+}